@@ -0,0 +1,206 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package certgen
+
+/*
+	Self-signed certificates for the https search server.
+	Taken from utils/generate_cert.go so the crawler can make its own
+	cert.pem and key.pem, with ECDSA keys as well as RSA.
+*/
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+//ErrNoHosts is returned generating a certificate without any hosts
+var ErrNoHosts = errors.New("missing hosts to generate a certificate for")
+
+//Options of a certificate
+type Options struct {
+	Hosts      []string  //hostnames and ips
+	ValidFrom  time.Time //now if zero
+	ValidFor   time.Duration
+	IsCA       bool   //its own certificate authority
+	ECDSACurve string //P224, P256, P384 or P521, an RSA key if empty
+	RSABits    int
+}
+
+//DefaultOptions are a year long P256 certificate for localhost
+func DefaultOptions() Options {
+	return Options{
+		Hosts:      []string{"localhost", "127.0.0.1", "::1"},
+		ValidFor:   365 * 24 * time.Hour,
+		ECDSACurve: "P256",
+		RSABits:    2048,
+	}
+}
+
+//Hosts split from a comma separated list
+func Hosts(list string) []string {
+	hosts := []string{}
+	for _, h := range strings.Split(list, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+func generateKey(o Options) (interface{}, error) {
+	switch o.ECDSACurve {
+	case "":
+		return rsa.GenerateKey(rand.Reader, o.RSABits)
+	case "P224":
+		return ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	case "P256":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "P384":
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "P521":
+		return ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	}
+	return nil, fmt.Errorf("unrecognized elliptic curve: %q", o.ECDSACurve)
+}
+
+func publicKey(priv interface{}) interface{} {
+	switch k := priv.(type) {
+	case *rsa.PrivateKey:
+		return &k.PublicKey
+	case *ecdsa.PrivateKey:
+		return &k.PublicKey
+	}
+	return nil
+}
+
+func pemBlock(priv interface{}) (*pem.Block, error) {
+	switch k := priv.(type) {
+	case *rsa.PrivateKey:
+		return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}, nil
+	case *ecdsa.PrivateKey:
+		b, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		return &pem.Block{Type: "EC PRIVATE KEY", Bytes: b}, nil
+	}
+	return nil, errors.New("unknown private key type")
+}
+
+//Generate a self-signed certificate and its key, both pem encoded
+func Generate(o Options) (certPEM []byte, keyPEM []byte, err error) {
+	if len(o.Hosts) == 0 {
+		return nil, nil, ErrNoHosts
+	}
+
+	priv, err := generateKey(o)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	notBefore := o.ValidFrom
+	if notBefore.IsZero() {
+		notBefore = time.Now()
+	}
+	notAfter := notBefore.Add(o.ValidFor)
+
+	//end of ASN.1 time
+	endOfTime := time.Date(2049, 12, 31, 23, 59, 59, 0, time.UTC)
+	if notAfter.After(endOfTime) {
+		notAfter = endOfTime
+	}
+
+	//browsers refuse a second certificate from the same issuer with the same serial
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"Acme Co"},
+		},
+		NotBefore: notBefore,
+		NotAfter:  notAfter,
+
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	//only rsa keys encrypt the tls key exchange
+	if _, ok := priv.(*rsa.PrivateKey); ok {
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+
+	for _, h := range o.Hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	if o.IsCA {
+		template.IsCA = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	}
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, publicKey(priv), priv)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, err := pemBlock(priv)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
+	keyPEM = pem.EncodeToMemory(block)
+	return certPEM, keyPEM, nil
+}
+
+//Write a new certificate and key, overwriting the files if they exist
+func Write(certFile, keyFile string, o Options) error {
+	certPEM, keyPEM, err := Generate(o)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+		return err
+	}
+	//the key is only readable by its owner
+	return os.WriteFile(keyFile, keyPEM, 0600)
+}
+
+//Ensure the certificate and key exist, writing new ones if either is missing. Returns true if they were written.
+func Ensure(certFile, keyFile string, o Options) (bool, error) {
+	_, certErr := os.Stat(certFile)
+	_, keyErr := os.Stat(keyFile)
+	if certErr == nil && keyErr == nil {
+		return false, nil
+	}
+	for _, err := range []error{certErr, keyErr} {
+		if err != nil && !os.IsNotExist(err) {
+			return false, err
+		}
+	}
+	if err := Write(certFile, keyFile, o); err != nil {
+		return false, err
+	}
+	return true, nil
+}
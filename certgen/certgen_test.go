@@ -0,0 +1,201 @@
+package certgen
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func parse(t *testing.T, certPEM []byte) *x509.Certificate {
+	t.Helper()
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		t.Fatalf("not a pem certificate: %q", certPEM)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestGenerate(t *testing.T) {
+	tests := []struct {
+		curve        string
+		keyType      string
+		encipherment bool
+	}{
+		{"", "RSA PRIVATE KEY", true},
+		{"P224", "EC PRIVATE KEY", false},
+		{"P256", "EC PRIVATE KEY", false},
+		{"P384", "EC PRIVATE KEY", false},
+		{"P521", "EC PRIVATE KEY", false},
+	}
+	for _, tt := range tests {
+		o := DefaultOptions()
+		o.ECDSACurve = tt.curve
+		o.RSABits = 1024
+		certPEM, keyPEM, err := Generate(o)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.curve, err)
+		}
+		if block, _ := pem.Decode(keyPEM); block == nil || block.Type != tt.keyType {
+			t.Errorf("%q: key is not a %s", tt.curve, tt.keyType)
+		}
+		if tt.curve != "P224" { //tls doesnt support P224
+			if _, err := tls.X509KeyPair(certPEM, keyPEM); err != nil {
+				t.Errorf("%q: key pair: %v", tt.curve, err)
+			}
+		}
+
+		cert := parse(t, certPEM)
+		if got := cert.KeyUsage&x509.KeyUsageKeyEncipherment != 0; got != tt.encipherment {
+			t.Errorf("%q: key encipherment %v, want %v", tt.curve, got, tt.encipherment)
+		}
+		if cert.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
+			t.Errorf("%q: missing digital signature usage", tt.curve)
+		}
+		if cert.IsCA || cert.KeyUsage&x509.KeyUsageCertSign != 0 {
+			t.Errorf("%q: not asked to be a CA", tt.curve)
+		}
+	}
+}
+
+func TestGenerateHosts(t *testing.T) {
+	o := DefaultOptions()
+	o.Hosts = []string{"example.com", "10.0.0.1", "*.example.org", "::1"}
+	certPEM, _, err := Generate(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := parse(t, certPEM)
+	if want := []string{"example.com", "*.example.org"}; !reflect.DeepEqual(cert.DNSNames, want) {
+		t.Errorf("DNSNames = %q, want %q", cert.DNSNames, want)
+	}
+	if len(cert.IPAddresses) != 2 || cert.IPAddresses[0].String() != "10.0.0.1" || cert.IPAddresses[1].String() != "::1" {
+		t.Errorf("IPAddresses = %v", cert.IPAddresses)
+	}
+	for _, h := range []string{"example.com", "www.example.org", "10.0.0.1", "::1"} {
+		if err := cert.VerifyHostname(h); err != nil {
+			t.Errorf("%s: %v", h, err)
+		}
+	}
+}
+
+func TestGenerateOptions(t *testing.T) {
+	o := DefaultOptions()
+	o.Hosts = nil
+	if _, _, err := Generate(o); err != ErrNoHosts {
+		t.Errorf("no hosts gave %v", err)
+	}
+
+	o = DefaultOptions()
+	o.ECDSACurve = "P999"
+	if _, _, err := Generate(o); err == nil {
+		t.Error("unknown curve gave no error")
+	}
+
+	o = DefaultOptions()
+	o.IsCA = true
+	o.ValidFrom = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	o.ValidFor = 100 * 365 * 24 * time.Hour
+	certPEM, _, err := Generate(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := parse(t, certPEM)
+	if !cert.IsCA || cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		t.Error("CA certificate cant sign certificates")
+	}
+	if !cert.NotBefore.Equal(o.ValidFrom) {
+		t.Errorf("NotBefore = %v, want %v", cert.NotBefore, o.ValidFrom)
+	}
+	if end := time.Date(2049, 12, 31, 23, 59, 59, 0, time.UTC); !cert.NotAfter.Equal(end) {
+		t.Errorf("NotAfter = %v, want capped at %v", cert.NotAfter, end)
+	}
+}
+
+func TestHosts(t *testing.T) {
+	tests := []struct {
+		list string
+		want []string
+	}{
+		{"", []string{}},
+		{"localhost", []string{"localhost"}},
+		{" a.com, 127.0.0.1 ,,b.com,", []string{"a.com", "127.0.0.1", "b.com"}},
+	}
+	for _, tt := range tests {
+		if got := Hosts(tt.list); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Hosts(%q) = %q, want %q", tt.list, got, tt.want)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := Write(certFile, keyFile, DefaultOptions()); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("key file mode %o, want 600", perm)
+	}
+	if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEnsure(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	written, err := Ensure(certFile, keyFile, DefaultOptions())
+	if err != nil || !written {
+		t.Fatalf("first Ensure = %v, %v", written, err)
+	}
+	cert, _ := os.ReadFile(certFile)
+	key, _ := os.ReadFile(keyFile)
+
+	//both exist, left alone
+	written, err = Ensure(certFile, keyFile, DefaultOptions())
+	if err != nil || written {
+		t.Fatalf("second Ensure = %v, %v", written, err)
+	}
+	if c, _ := os.ReadFile(certFile); !bytes.Equal(c, cert) {
+		t.Error("existing certificate overwritten")
+	}
+	if k, _ := os.ReadFile(keyFile); !bytes.Equal(k, key) {
+		t.Error("existing key overwritten")
+	}
+
+	//either missing, both rewritten as a matching pair
+	for _, missing := range []string{certFile, keyFile} {
+		if err := os.Remove(missing); err != nil {
+			t.Fatal(err)
+		}
+		written, err = Ensure(certFile, keyFile, DefaultOptions())
+		if err != nil || !written {
+			t.Fatalf("Ensure without %s = %v, %v", filepath.Base(missing), written, err)
+		}
+		if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+			t.Errorf("without %s: %v", filepath.Base(missing), err)
+		}
+	}
+
+	o := DefaultOptions()
+	o.Hosts = nil
+	os.Remove(keyFile)
+	if _, err := Ensure(certFile, keyFile, o); err != ErrNoHosts {
+		t.Errorf("Ensure with no hosts gave %v", err)
+	}
+}
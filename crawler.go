@@ -15,37 +15,121 @@ import (
 	"strconv"
 	"net/http"
 	"net/url"
+	"io"
 	"io/ioutil"
 	"strings"
-	"regexp"
 	"sync"
+	"errors"
+	"net"
+	"context"
+	"os/signal"
+	"syscall"
 
 	"github.com/steveyen/gkvlite"
 	"code.google.com/p/go.net/html"	
 
 	"./websearch"
+	"./certgen"
+	"./robots"
+	"./frontier"
+	"./sitemap"
+	"./fetcher"
+	"./health"
+	"./extract"
+	"./posting"
+	"./snippet"
+	"./tokenizer"
 )
 
 //dirty...
 type RespChan chan *http.Response
-type StrChan chan string
 
 var store *gkvlite.Store
 var all_urls bool
+var useragent = "gofish"
+var client *http.Client
+var sitemapClient *http.Client
+var robotsCache *robots.Cache
+var crawlFrontier *frontier.Frontier
+var hostHealth *health.Tracker
 
 var responses RespChan
-var scanurls StrChan
 var waitsave sync.WaitGroup
 
+//roots waiting on sitemap discovery, and those already handed over
+var sitemaproots chan string
+var sitemapseen = map[string]bool{}
+var sitemaplock sync.Mutex
+
+//requesters record fetch outcomes from several threads
+var statuslock sync.Mutex
+
+//the crawl loop, response processor and sitemapper all write to the queue
+var queuelock sync.Mutex
+
+//closed on interrupt, the crawl loop then flushes the store and exits
+var quit = make(chan bool)
+var searchServer *websearch.Server
+
+//referring pages kept per link target
+const maxReferrers = 50
+
+var hostDelay = flag.Duration("host-delay", 1*time.Second, "Minimum delay between requests to the same host")
+var hostConcurrency = flag.Int("host-concurrency", 1, "Max concurrent requests to the same host")
+var threads = flag.Int("threads", 10, "Number of http requester threads")
+var failThreshold = flag.Int("fail-threshold", 5, "Consecutive failures before a host is blacklisted")
+var backoff = flag.Duration("backoff", 5*time.Minute, "First blacklist cooldown, doubles each time a host is blacklisted again")
+var maxBackoff = flag.Duration("max-backoff", 24*time.Hour, "Longest blacklist cooldown")
+var retryBackoff = flag.Duration("retry-backoff", 1*time.Minute, "First wait before refetching a url that failed, doubles with each failure up to -max-backoff")
+var indexText = flag.Bool("index-text", false, "Index keywords of text/plain documents too")
+var stopwordDir = flag.String("stopwords", tokenizer.DefaultStopwordDir, "Directory of <lang>.txt stopword files")
+var stopwordLangs = flag.String("stopword-langs", "en", "Comma separated stopword languages, empty for every file in -stopwords")
+var noStopwords = flag.Bool("no-stopwords", false, "Index every word, stopwords included")
+
+//http client settings, these override anything in the -config file
+var configFile = flag.String("config", "", "Json file with http client settings")
+var userAgentFlag = flag.String("user-agent", fetcher.DefaultConfig().UserAgent, "User-Agent sent with every request")
+var connectTimeout = flag.Duration("connect-timeout", fetcher.DefaultConfig().ConnectTimeout, "Connect and tls handshake timeout")
+var readTimeout = flag.Duration("read-timeout", fetcher.DefaultConfig().ReadTimeout, "Timeout waiting on response headers")
+var totalTimeout = flag.Duration("timeout", fetcher.DefaultConfig().Timeout, "Timeout for a whole request including the body")
+var maxBody = flag.Int64("max-body", fetcher.DefaultConfig().MaxBodyBytes, "Max bytes read from a response body")
+var maxRedirects = flag.Int("max-redirects", fetcher.DefaultConfig().MaxRedirects, "Max redirects followed, 0 to not follow")
+var acceptEncoding = flag.String("accept-encoding", fetcher.DefaultConfig().AcceptEncoding, "Accepted content encodings (gzip, deflate)")
+
+//search server started by start-http and start-https
+var searchAddr = flag.String("search-addr", websearch.DefaultConfig().Addr, "Address the search server listens on")
+var certFile = flag.String("cert", "cert.pem", "TLS certificate for start-https")
+var keyFile = flag.String("key", "key.pem", "TLS key for start-https")
+var searchReadTimeout = flag.Duration("search-read-timeout", websearch.DefaultConfig().ReadTimeout, "Search server request read timeout")
+var searchWriteTimeout = flag.Duration("search-write-timeout", websearch.DefaultConfig().WriteTimeout, "Search server response write timeout")
+var autoCert = flag.Bool("auto-cert", false, "Generate a self-signed -cert and -key for start-https if they dont exist")
+
+//hosts of the certificate -auto-cert makes, gen-cert takes its own options
+var certHosts = flag.String("cert-hosts", strings.Join(certgen.DefaultOptions().Hosts, ","), "Comma separated hostnames and ips of the certificate -auto-cert generates")
+
+//max urls held in memory by the frontier, the rest wait in the queue collection
+const maxFrontier = 5000
+
 //Main function
 func main() {
 	flag.Parse()	
 	args := flag.Args()
 
+	//doesnt need the db, so dont open it
+	if len(args)>0 && args[0]=="gen-cert" {
+		genCert(args[1:])
+		return
+	}
+
 	responses = make(chan *http.Response, 10)
-	scanurls = make(chan string, 10)	
+	sitemaproots = make(chan string, 100)
 	all_urls = false
 
+	//before anything gets indexed
+	if _, err := tokenizer.Configure(*stopwordDir, *stopwordLangs, *noStopwords); err!=nil {
+		fmt.Println("Stopwords:", err)
+	}
+
 	/*
 	if len(args)==0 || args[0]!="no-compact" {
 		compactDb()
@@ -68,9 +152,34 @@ func main() {
 	//grab collections
 	queue := store.SetCollection("scan-queue", nil)
 	log := store.SetCollection("scan-log", nil)
-	index := store.SetCollection("keyword-index", nil)
+	index := posting.Open(store)
 	meta := store.SetCollection("meta", nil)
 	title := store.SetCollection("title", nil)
+	blocked := store.SetCollection("robots-blocked", nil)
+	sitemaps := store.SetCollection("sitemaps", nil)
+	sitemapurls := store.SetCollection("sitemap-urls", nil)
+	validators := store.SetCollection("http-cache", nil)
+	status := store.SetCollection("fetch-status", nil)
+	links := store.SetCollection("links", nil)
+	texts := store.SetCollection("page-text", nil)
+
+	//http client for every fetch
+	config := httpConfig()
+	useragent = config.UserAgent
+	client = fetcher.NewClient(config)
+
+	//sitemaps can be up to 50MB, the sitemap package caps them itself
+	sitemapConfig := config
+	sitemapConfig.MaxBodyBytes = 0
+	sitemapClient = fetcher.NewClient(sitemapConfig)
+
+	robotsCache = robots.NewCache(store.SetCollection("robots", nil), useragent)
+	robotsCache.Client = client
+
+	crawlFrontier = frontier.New(*hostDelay, *hostConcurrency)
+	crawlFrontier.DelayFunc = robotsCache.CrawlDelay
+
+	hostHealth = health.NewTracker(store.SetCollection("host-health", nil), *failThreshold, *backoff, *maxBackoff)
 
 	//todo: root-domain scoring algo
 
@@ -79,24 +188,45 @@ func main() {
 		return
 	}
 
+	//a db from before the binary posting lists has to be converted before anything is indexed into it
+	if !index.Current() {
+		migrateIndex(index)
+		if !index.Current() {
+			fmt.Println("Fatal: the index couldnt be migrated, see migrate-index")
+			return
+		}
+		fmt.Println("Indexed hosts of", index.IndexHosts(), "docs")
+		store.Flush()
+	}
+
 	//add any extra domains from command line to queue
 	for i:=0; i<len(args); i++ {
 		if (args[i]=="all-urls") {
 			all_urls = true
 		} else if (args[i]=="start-http") {
-			websearch.StartServer()
+			startSearchServer(false)
 		} else if (args[i]=="start-https") {
-			websearch.StartServerSSL()
+			startSearchServer(true)
 		} else {
 			queueAndCleanUrl(args[i], queue)
 		}
 	}
 
+	//stop between urls rather than mid-write
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		fmt.Println("Stopping...")
+		close(quit)
+	}()
+
 	//start up threads
-	for i:=0; i<10; i++ {
-		go threadHttpRequester()
+	for i:=0; i<*threads; i++ {
+		go threadHttpRequester(validators, status)
 	}
-	go threadResponseProcessor(queue, log, index, meta, title)
+	go threadResponseProcessor(queue, log, index, meta, title, validators, links, texts)
+	go threadSitemapper(queue, sitemaps, sitemapurls)
 	go threadSaver()
 	
 	//crawl
@@ -105,17 +235,119 @@ func main() {
 		queueLog(queue, log)
 
 		//start procesing the queue
-		processQueue(queue, log, index, meta, title)
+		processQueue(queue, log, blocked, status)
 		
 		//write kvstore
 		store.Flush()
 
 		fmt.Println("Sleeping...")
-		time.Sleep(3000 * time.Millisecond)	
+		select {
+			case <-quit:
+				shutdown(f)
+				return
+			case <-time.After(3000 * time.Millisecond):
+		}
 	}
 
 }
 
+//true once an interrupt has been received
+func quitting() bool {
+	select {
+		case <-quit:
+			return true
+		default:
+			return false
+	}
+}
+
+//Stop the search server, then write and close the store
+func shutdown(f *os.File) {
+	if searchServer!=nil {
+		fmt.Println("Shutting down search server...")
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		searchServer.Shutdown(ctx)
+	}
+
+	//let a save in progress finish first
+	waitsave.Wait()
+	fmt.Println("Saving db...")
+	err := store.Flush()
+	if err!=nil {
+		fmt.Println("Err-Save: ", err)
+	}
+	f.Close()
+}
+
+//Start the search server in the background, the crawl loop shuts it down gracefully on interrupt
+func startSearchServer(tls bool) {
+	config := websearch.DefaultConfig()
+	config.Addr = *searchAddr
+	config.ReadTimeout = *searchReadTimeout
+	config.WriteTimeout = *searchWriteTimeout
+	if tls {
+		config.CertFile = *certFile
+		config.KeyFile = *keyFile
+
+		if *autoCert {
+			opts := certgen.DefaultOptions()
+			opts.Hosts = certgen.Hosts(*certHosts)
+			generated, err := certgen.Ensure(config.CertFile, config.KeyFile, opts)
+			if err!=nil {
+				fmt.Println("Err-Cert: ", err)
+				return
+			}
+			if generated {
+				fmt.Println("Generated self-signed "+config.CertFile+" and "+config.KeyFile+" for "+*certHosts)
+			}
+		}
+	}
+
+	server, err := websearch.NewServer(config)
+	if err!=nil {
+		fmt.Println("Err-Search: ", err)
+		return
+	}
+
+	searchServer = server
+	go func() {
+		fmt.Println("Search server on "+config.Addr)
+		err := server.ListenAndServe()
+		if err!=nil {
+			fmt.Println("Err-Search: ", err)
+		}
+	}()
+}
+
+//Write a self-signed certificate and key, with options parsed from the args after gen-cert
+func genCert(args []string) {
+	defaults := certgen.DefaultOptions()
+	flags := flag.NewFlagSet("gen-cert", flag.ExitOnError)
+	hosts := flags.String("host", strings.Join(defaults.Hosts, ","), "Comma separated hostnames and ips to generate a certificate for")
+	cert := flags.String("cert", *certFile, "Certificate file to write")
+	key := flags.String("key", *keyFile, "Key file to write")
+	duration := flags.Duration("duration", defaults.ValidFor, "How long the certificate is valid for")
+	curve := flags.String("ecdsa-curve", defaults.ECDSACurve, "ECDSA curve of the key (P224, P256, P384, P521), empty for RSA")
+	bits := flags.Int("rsa-bits", defaults.RSABits, "Size of an RSA key")
+	ca := flags.Bool("ca", false, "Whether the certificate is its own certificate authority")
+	flags.Parse(args)
+
+	opts := defaults
+	opts.Hosts = certgen.Hosts(*hosts)
+	opts.ValidFor = *duration
+	opts.ECDSACurve = *curve
+	opts.RSABits = *bits
+	opts.IsCA = *ca
+
+	err := certgen.Write(*cert, *key, opts)
+	if err!=nil {
+		fmt.Println("Err-Cert: ", err)
+		os.Exit(1)
+	}
+	fmt.Println("Written "+*cert+" and "+*key+" for "+strings.Join(opts.Hosts, ","))
+}
+
 //Add to queue, items taht havent been crawled recently
 func queueLog(queue *gkvlite.Collection, log *gkvlite.Collection) {
 	fmt.Println("Checking log...")
@@ -140,43 +372,237 @@ func queueLog(queue *gkvlite.Collection, log *gkvlite.Collection) {
 	})
 }
 
-func threadHttpRequester() {
-	theurl := <- scanurls //wait for first one
-	httpRequester(theurl)
-	
-	for theurl := range scanurls {
+func threadHttpRequester(validators *gkvlite.Collection, status *gkvlite.Collection) {
+	for {
+		theurl := crawlFrontier.Next() //blocks until a host is free
 		waitsave.Wait()
-		httpRequester(theurl)
+
+		//host may have been blacklisted since it was queued
+		if blocked, _ := hostHealth.Blocked(theurl); blocked {
+			crawlFrontier.Done(theurl)
+			crawlFrontier.Forget(theurl)
+			continue
+		}
+
+		httpRequester(theurl, validators, status)
+		crawlFrontier.Done(theurl)
 	}
 }
 
-func httpRequester(theurl string) {
+func httpRequester(theurl string, validators *gkvlite.Collection, status *gkvlite.Collection) {
 	fmt.Println("Requesting: "+theurl)
-	resp, err := http.Get(theurl)
+
+	req, err := http.NewRequest("GET", theurl, nil)
+	if err != nil {
+		fmt.Println("Err-Get: ", err)
+		recordFetch(status, theurl, 0, "", "invalid")
+		crawlFrontier.Forget(theurl)
+		return
+	}
+	req.Header.Set("User-Agent", useragent)
+
+	//conditional get if we have seen it before, stored as etag\nlast-modified
+	cached, err := validators.Get([]byte(theurl))
+	if err==nil && cached!=nil {
+		parts := strings.SplitN(string(cached), "\n", 2)
+		if parts[0]!="" {
+			req.Header.Set("If-None-Match", parts[0])
+		}
+		if len(parts)>1 && parts[1]!="" {
+			req.Header.Set("If-Modified-Since", parts[1])
+		}
+	}
+
+	resp, err := client.Do(req)
 	if err != nil {
 		fmt.Println("Err-Get: ", err)
-		//todo: delete url from log & queue if 404, or store in a broken link collection?
+		recordFetch(status, theurl, 0, "", classifyError(err))
+		hostFailure(theurl)
+		crawlFrontier.Forget(theurl)
 	} else {
+		recordFetch(status, theurl, resp.StatusCode, resp.Request.URL.String(), "")
+		if resp.StatusCode>=500 || resp.StatusCode==http.StatusTooManyRequests {
+			hostFailure(theurl)
+		} else {
+			hostHealth.Success(theurl)
+		}
 		resp.Request.RequestURI = theurl //hack. docs say not to do this, but its blank otherwise, should custom type it
 		responses <- resp
 	}
 }
 
-func threadResponseProcessor(queue *gkvlite.Collection, log *gkvlite.Collection, index *gkvlite.Collection, meta *gkvlite.Collection, title *gkvlite.Collection) {
+//Count a failure against the url's host, noting when it gets blacklisted
+func hostFailure(theurl string) {
+	if hostHealth.Failure(theurl) {
+		_, until := hostHealth.Blocked(theurl)
+		fmt.Println("Blacklisting "+health.HostOf(theurl)+" until "+until.String())
+	}
+}
+
+//Record the outcome of a fetch as code\tfinal url\terror class\ttime\tfailures in a row\tretry after
+func recordFetch(status *gkvlite.Collection, theurl string, code int, finalurl string, errclass string) {
+	statuslock.Lock()
+	defer statuslock.Unlock()
+
+	failures := 0
+	retry := int64(0)
+	if fetchFailed(code) {
+		failures = 1
+		prev, err := status.Get([]byte(theurl))
+		if err==nil && prev!=nil {
+			parts := strings.Split(string(prev), "\t")
+			if len(parts)>=6 {
+				n, _ := strconv.Atoi(parts[4])
+				failures = n+1
+			}
+		}
+		retry = time.Now().Add(retryDelay(failures)).Unix()
+	}
+
+	val := strconv.Itoa(code)+"\t"+finalurl+"\t"+errclass+"\t"+strconv.FormatInt(time.Now().Unix(), 10)+
+		"\t"+strconv.Itoa(failures)+"\t"+strconv.FormatInt(retry, 10)
+	if err := status.Set([]byte(theurl), []byte(val)); err!=nil {
+		fmt.Println("Err-Status: ", err)
+	}
+}
+
+//Network errors (code 0), server errors and rate limiting are worth another try later
+func fetchFailed(code int) bool {
+	return code==0 || code>=500 || code==http.StatusTooManyRequests
+}
+
+//Wait before refetching a url after its nth failure in a row
+func retryDelay(failures int) time.Duration {
+	d := *retryBackoff
+	for i := 1; i<failures && d<*maxBackoff; i++ {
+		d *= 2
+	}
+	if d>*maxBackoff {
+		d = *maxBackoff
+	}
+	return d
+}
+
+//Whether theurl is still backing off after failing, and until when
+func retryWait(status *gkvlite.Collection, theurl string) (bool, time.Time) {
+	statuslock.Lock()
+	defer statuslock.Unlock()
+
+	val, err := status.Get([]byte(theurl))
+	if err!=nil || val==nil {
+		return false, time.Time{}
+	}
+	parts := strings.Split(string(val), "\t")
+	if len(parts)<6 {
+		return false, time.Time{}
+	}
+	t, err := strconv.ParseInt(parts[5], 10, 64)
+	if err!=nil || t==0 {
+		return false, time.Time{}
+	}
+	until := time.Unix(t, 0)
+	return time.Now().Before(until), until
+}
+
+//Rough class of a failed fetch
+func classifyError(err error) string {
+	var dnserr *net.DNSError
+	var neterr net.Error
+
+	if errors.As(err, &dnserr) {
+		return "dns"
+	} else if errors.As(err, &neterr) && neterr.Timeout() {
+		return "timeout"
+	} else if strings.Contains(err.Error(), "connection refused") {
+		return "refused"
+	} else if strings.Contains(err.Error(), "redirects") {
+		return "redirects"
+	} else if strings.Contains(err.Error(), "x509") || strings.Contains(err.Error(), "tls") {
+		return "tls"
+	}
+	return "other"
+}
+
+func threadResponseProcessor(queue *gkvlite.Collection, log *gkvlite.Collection, index *posting.Index, meta *gkvlite.Collection, title *gkvlite.Collection, validators *gkvlite.Collection, links *gkvlite.Collection, texts *gkvlite.Collection) {
 	resp := <- responses //wait for first one
-	responseProcessor(resp, queue, log, index, meta, title)
+	responseProcessor(resp, queue, log, index, meta, title, validators, links, texts)
 
 	for resp := range responses {
 		waitsave.Wait()
-		responseProcessor(resp, queue, log, index, meta, title)
+		responseProcessor(resp, queue, log, index, meta, title, validators, links, texts)
 	}
 }
 
-func responseProcessor(resp *http.Response, queue *gkvlite.Collection, log *gkvlite.Collection, index *gkvlite.Collection, meta *gkvlite.Collection, title *gkvlite.Collection) {
+func responseProcessor(resp *http.Response, queue *gkvlite.Collection, log *gkvlite.Collection, index *posting.Index, meta *gkvlite.Collection, title *gkvlite.Collection, validators *gkvlite.Collection, links *gkvlite.Collection, texts *gkvlite.Collection) {
 	theurl := resp.Request.RequestURI
 
 	waitsave.Wait()
 
+	//unchanged since last time, just refresh the log and leave the index alone
+	if resp.StatusCode==http.StatusNotModified {
+		resp.Body.Close()
+		fmt.Println("Not modified: "+theurl)
+		fmt.Println()
+		finishUrl(theurl, queue, log)
+		return
+	}
+
+	//gone, drop it from the index. stays logged so it isnt refetched every pass
+	if resp.StatusCode==http.StatusNotFound || resp.StatusCode==http.StatusGone {
+		resp.Body.Close()
+		fmt.Println("Broken ("+strconv.Itoa(resp.StatusCode)+"): "+theurl)
+		fmt.Println()
+		if err := index.Remove(theurl); err!=nil {
+			fmt.Println("Err-Index: ", err)
+		}
+		meta.Delete([]byte(theurl))
+		title.Delete([]byte(theurl))
+		texts.Delete([]byte(theurl))
+		validators.Delete([]byte(theurl))
+		finishUrl(theurl, queue, log)
+		return
+	}
+
+	//server trouble or rate limited, leave it queued to try again once its backoff is over
+	if resp.StatusCode!=0 && fetchFailed(resp.StatusCode) {
+		resp.Body.Close()
+		fmt.Println("Failed ("+strconv.Itoa(resp.StatusCode)+"), retrying later: "+theurl)
+		fmt.Println()
+		crawlFrontier.Forget(theurl)
+		return
+	}
+
+	//redirects we were told not to follow, queue where they point instead
+	if resp.StatusCode>=300 && resp.StatusCode<400 {
+		resp.Body.Close()
+		loc, err := resp.Location()
+		if err==nil {
+			addReferrer(queueAndCleanUrl(loc.String(), queue), theurl, links)
+		}
+		finishUrl(theurl, queue, log)
+		return
+	}
+
+	//other client errors arent worth indexing
+	if resp.StatusCode>=400 {
+		resp.Body.Close()
+		fmt.Println("Skipping ("+strconv.Itoa(resp.StatusCode)+"): "+theurl)
+		fmt.Println()
+		finishUrl(theurl, queue, log)
+		return
+	}
+
+	//remember validators for the next recrawl
+	if resp.StatusCode==http.StatusOK {
+		etag := resp.Header.Get("ETag")
+		lastmod := resp.Header.Get("Last-Modified")
+		if etag!="" || lastmod!="" {
+			validators.Set([]byte(theurl), []byte(etag+"\n"+lastmod))
+		} else {
+			validators.Delete([]byte(theurl))
+		}
+	}
+
 	fmt.Println("Indexing: "+theurl)
     start:=time.Now()
 
@@ -185,6 +611,7 @@ func responseProcessor(resp *http.Response, queue *gkvlite.Collection, log *gkvl
 	if strings.Contains(resp.Header.Get("Content-Type"), "text/html") {
 		
 		fmt.Println("Scraping html...")
+		page := newPageText(index, theurl)
 		p := html.NewTokenizer(resp.Body)
 	    for { 
 	        tokenType := p.Next() 
@@ -192,9 +619,29 @@ func responseProcessor(resp *http.Response, queue *gkvlite.Collection, log *gkvl
 	            break
 	        }       
 	        token := p.Token()
-	        scrapeToken(token, p, theurl, queue, index, meta, title)
+	        scrapeToken(token, p, theurl, queue, index, meta, title, links, page)
+	    }
+	    resp.Body.Close()
+	    readError(p.Err(), theurl)
+
+	    //everything visible on the page
+	    addKeywords(page.terms, page.doc, page.body.String(), posting.Body)
+	    fmt.Println("Keywords:", page.terms.Len())
+	    if err := index.Write(page.terms); err!=nil {
+	    	fmt.Println("Err-Index: ", err)
 	    }
 
+	    //kept for result snippets
+	    texts.Set([]byte(theurl), snippet.Compress(page.body.String()))
+
+	} else if isJavaScript(resp.Header.Get("Content-Type")) {
+
+		fmt.Println("Scraping javascript...")
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		readError(err, theurl)
+		queueLinks(extract.JavaScript(string(body), theurl), theurl, queue, links)
+
 	} else if strings.Contains(resp.Header.Get("Content-Type"), "application/") {
 
 		resp.Body.Close()
@@ -210,12 +657,24 @@ func responseProcessor(resp *http.Response, queue *gkvlite.Collection, log *gkvl
 	
 		fmt.Println("Using "+resp.Header.Get("Content-Type")+" as text...")
 		body, err := ioutil.ReadAll(resp.Body)
-		if err!=nil {
-			fmt.Println("Err-Read: ", err)
+		resp.Body.Close()
+		readError(err, theurl)
+
+		if strings.Contains(resp.Header.Get("Content-Type"), "text/css") {
+			queueLinks(extract.CSS(string(body), theurl), theurl, queue, links)
+		} else {
+			queueLinks(extract.Text(string(body)), theurl, queue, links)
+
+			//keywords are optional, plain text is mostly logs and such
+			if *indexText && strings.Contains(resp.Header.Get("Content-Type"), "text/plain") {
+				page := newPageText(index, theurl)
+				addKeywords(page.terms, page.doc, string(body), posting.Body)
+				if err := index.Write(page.terms); err!=nil {
+					fmt.Println("Err-Index: ", err)
+				}
+				texts.Set([]byte(theurl), snippet.Compress(string(body)))
+			}
 		}
-		//TODO: regex to extract urls to queue, probably wont bother with keywords on these
-		_=string(body)
-		defer resp.Body.Close()
 	}
 
 	//stats
@@ -226,9 +685,62 @@ func responseProcessor(resp *http.Response, queue *gkvlite.Collection, log *gkvl
 	fmt.Println("Finished: "+theurl)	    
 	fmt.Println()
 
-    //log serialized time of indexing
+	finishUrl(theurl, queue, log)
+}
+
+//report a body read error. a body over -max-body is still indexed up to the cap, but say so
+func readError(err error, theurl string) {
+	if err==nil || err==io.EOF {
+		return
+	}
+	if err==fetcher.ErrTruncated {
+		fmt.Println("Truncated at max-body, indexing the start only: "+theurl)
+		return
+	}
+	fmt.Println("Err-Read: ", err)
+}
+
+//javascript comes under a few different content types
+func isJavaScript(contenttype string) bool {
+	return strings.Contains(contenttype, "javascript") || strings.Contains(contenttype, "ecmascript")
+}
+
+//queue extracted links, remembering where they came from
+func queueLinks(found []string, from string, queue *gkvlite.Collection, links *gkvlite.Collection) {
+	for _, l := range found {
+		addReferrer(queueAndCleanUrl(l, queue), from, links)
+	}
+}
+
+//log serialized time of indexing and take the url off the queue
+func finishUrl(theurl string, queue *gkvlite.Collection, log *gkvlite.Collection) {
     log.Set([]byte(theurl), []byte(strconv.FormatInt(time.Now().Unix(), 10)))
-    queue.Delete([]byte(theurl))
+    dequeue(theurl, queue)
+    crawlFrontier.Forget(theurl)
+}
+
+//Remember that from links to target, for reporting broken links
+func addReferrer(target string, from string, links *gkvlite.Collection) {
+	if target=="" || target==from {
+		return
+	}
+
+	val, err := links.Get([]byte(target))
+	refs := []string{}
+	if err==nil && val!=nil {
+		refs = strings.Split(string(val), "\n")
+	}
+	if len(refs)>=maxReferrers {
+		return
+	}
+	for i:=0; i<len(refs); i++ {
+		if refs[i]==from {
+			return
+		}
+	}
+
+	refs = append(refs, from)
+	links.Set([]byte(target), []byte(strings.Join(refs, "\n")))
 }
 
 func threadSaver() {
@@ -242,13 +754,50 @@ func threadSaver() {
 	}
 }
 
+//Build the http client config from defaults, the config file, then flags
+func httpConfig() fetcher.Config {
+	config := fetcher.DefaultConfig()
+
+	if *configFile!="" {
+		err := fetcher.LoadConfig(*configFile, &config)
+		if err!=nil {
+			fmt.Println("Err-Config: ", err)
+		}
+	}
+
+	//only flags actually given on the command line win over the file
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+			case "user-agent":
+				config.UserAgent = *userAgentFlag
+			case "connect-timeout":
+				config.ConnectTimeout = *connectTimeout
+			case "read-timeout":
+				config.ReadTimeout = *readTimeout
+			case "timeout":
+				config.Timeout = *totalTimeout
+			case "max-body":
+				config.MaxBodyBytes = *maxBody
+			case "max-redirects":
+				config.MaxRedirects = *maxRedirects
+			case "accept-encoding":
+				config.AcceptEncoding = *acceptEncoding
+		}
+	})
+
+	return config
+}
+
 //Processes the entire queue top to bottom. 
-func processQueue(queue *gkvlite.Collection, log *gkvlite.Collection, index *gkvlite.Collection, meta *gkvlite.Collection, title *gkvlite.Collection) {
+func processQueue(queue *gkvlite.Collection, log *gkvlite.Collection, blocked *gkvlite.Collection, status *gkvlite.Collection) {
 	fmt.Println("Crawling...")
 	
 	queue.VisitItemsAscend([]byte(""), true, func(i *gkvlite.Item) bool {
 
 		waitsave.Wait()
+		if quitting() {
+			return false
+		}
 
 		//remove trailing folder slash
 		theurl:=string(i.Key)
@@ -275,19 +824,97 @@ func processQueue(queue *gkvlite.Collection, log *gkvlite.Collection, index *gkv
 	    }
 
 	    if datediff >= 7.0 {
-	    	scanurls <- string(i.Key)
-			//todo: gofish headers etc
-	    	//todo: timeout-based domain blacklist to check before queueing
+	    	//host is cooling down after failures, leave it queued for later
+	    	if blacklisted, until := hostHealth.Blocked(string(i.Key)); blacklisted {
+	    		fmt.Println("Skipping, host blacklisted until", until)
+	    		return crawlFrontier.Len() < maxFrontier
+	    	}
+
+	    	//failed recently, wait out its backoff
+	    	if waiting, until := retryWait(status, string(i.Key)); waiting {
+	    		fmt.Println("Skipping, retrying after", until)
+	    		return crawlFrontier.Len() < maxFrontier
+	    	}
+
+	    	//robots.txt errored, leave it queued until it can be fetched again
+	    	if unavailable, until := robotsCache.Unavailable(string(i.Key)); unavailable {
+	    		fmt.Println("Skipping, robots.txt unavailable until", until)
+	    		return crawlFrontier.Len() < maxFrontier
+	    	}
+
+	    	//respect robots.txt, keep a record of what we were kept out of
+	    	if !robotsCache.Allowed(string(i.Key)) {
+	    		fmt.Println("Disallowed by robots.txt: "+string(i.Key))
+	    		blocked.Set(i.Key, []byte(strconv.FormatInt(time.Now().Unix(), 10)))
+	    		dequeue(string(i.Key), queue)
+	    		return true
+	    	}
+
+	    	crawlFrontier.Push(string(i.Key))
 		} else {
 			fmt.Println("Skipping, last indexed", datediff, "days ago")
 		}
 
-	    return true
+	    //leave the rest in the queue collection until the frontier drains
+	    return crawlFrontier.Len() < maxFrontier
 	})
 }
 
 //queue a url to be indexed, removing non-relevant parts, etc
 func queueAndCleanUrl(theurl string, queue *gkvlite.Collection) string {
+	theurl = stripUrl(theurl)
+
+	//strip down to domain/subdomain only unless overridden
+	if !all_urls {
+		u, err := url.Parse(theurl)
+		if err==nil {
+
+			if u.Scheme=="" {
+				theurl = "http://"+u.Host	
+			} else {
+				theurl = u.Scheme+"://"+u.Host
+			}
+			
+			enqueue(theurl, queue)
+		}
+	} else {	
+		if enqueue(theurl, queue) {
+			queueSitemaps(theurl)
+		}
+	}
+
+	return theurl
+}
+
+//add a url to the queue collection, true if it wasnt already there
+func enqueue(theurl string, queue *gkvlite.Collection) bool {
+	queuelock.Lock()
+	defer queuelock.Unlock()
+
+	test, _ := queue.Get([]byte(theurl))
+	if test!=nil {
+		return false
+	}
+	fmt.Println("Queueing "+theurl)
+	if err := queue.Set([]byte(theurl), []byte("")); err!=nil {
+		fmt.Println("Err-Queue: ", err)
+		return false
+	}
+	return true
+}
+
+//take a url off the queue collection
+func dequeue(theurl string, queue *gkvlite.Collection) {
+	queuelock.Lock()
+	defer queuelock.Unlock()
+
+	if _, err := queue.Delete([]byte(theurl)); err!=nil {
+		fmt.Println("Err-Queue: ", err)
+	}
+}
+
+//remove fragments, query strings and trailing slashes
+func stripUrl(theurl string) string {
 	if strings.Contains(theurl, "#") {
 		urlpart := strings.Split(theurl, "#")
 		theurl = urlpart[0]
@@ -302,48 +929,153 @@ func queueAndCleanUrl(theurl string, queue *gkvlite.Collection) string {
 		theurl = strings.TrimRight(theurl, "/")
 	}
 
-	//strip down to domain/subdomain only unless overridden
-	if !all_urls {
-		u, err := url.Parse(theurl)
-		if err==nil {
+	return theurl
+}
 
-			if u.Scheme=="" {
-				theurl = "http://"+u.Host	
-			} else {
-				theurl = u.Scheme+"://"+u.Host
-			}
-			
-			test, _ := queue.Get([]byte(theurl))
-			if test==nil {
-				fmt.Println("Queueing "+theurl)
-				queue.Set([]byte(theurl), []byte(""))
+//index anchor text against the link target, as long as the target is what actually gets crawled
+func addAnchorText(link string, cleaned string, from string, linktext string, index *posting.Index, page *pageText) {
+	if linktext=="" || cleaned=="" || cleaned==from {
+		return
+	}
+	//without all-urls a deep link would pin its text on the domain's front page
+	if stripUrl(link)!=cleaned {
+		return
+	}
+	doc, _ := index.DocID(cleaned, true)
+	addKeywords(page.terms, doc, linktext, posting.Anchor)
+}
+
+//hand a newly seen domain over for sitemap discovery. only useful with all-urls,
+//otherwise everything in the sitemap collapses back down to the domain anyway
+func queueSitemaps(theurl string) {
+	u, err := url.Parse(theurl)
+	if err!=nil || u.Host=="" {
+		return
+	}
+	root := u.Scheme+"://"+u.Host
+	if u.Scheme=="" {
+		root = "http://"+u.Host
+	}
+
+	sitemaplock.Lock()
+	defer sitemaplock.Unlock()
+	if sitemapseen[root] {
+		return
+	}
+
+	select {
+		case sitemaproots <- root:
+			sitemapseen[root] = true
+		default:
+			//backed up, we will see the domain again
+	}
+}
+
+//Seeds the queue from sitemaps of newly seen domains
+func threadSitemapper(queue *gkvlite.Collection, sitemaps *gkvlite.Collection, sitemapurls *gkvlite.Collection) {
+	for root := range sitemaproots {
+		waitsave.Wait()
+
+		//only rediscover once a week
+		last, err := sitemaps.Get([]byte(root))
+		if err==nil && last!=nil {
+			t, err := strconv.ParseInt(string(last), 10, 64)
+			if err==nil && time.Now().Sub(time.Unix(t, 0)).Hours()/24.0 < 7.0 {
+				continue
 			}
 		}
-	} else {	
-		test, _ := queue.Get([]byte(theurl))
-		if test==nil {
-			fmt.Println("Queueing "+theurl)
-			queue.Set([]byte(theurl), []byte(""))
+
+		//robots.txt Sitemap: lines plus the usual location
+		locs := robotsCache.Sitemaps(root)
+		locs = append(locs, root+"/sitemap.xml")
+
+		seen := map[string]bool{}
+		for _, loc := range locs {
+			if seen[loc] || !robotsCache.Allowed(loc) {
+				continue
+			}
+			seen[loc] = true
+
+			err := sitemap.Walk(sitemapClient, useragent, loc, func(u sitemap.URL) {
+				//a sitemap only speaks for its own host
+				if !sameHost(u.Loc, root) {
+					return
+				}
+				sitemapurls.Set([]byte(u.Loc), []byte(u.LastMod+"\t"+u.Priority))
+				queueAndCleanUrl(u.Loc, queue)
+			})
+			if err!=nil {
+				fmt.Println("Err-Sitemap: ", err)
+			}
 		}
+
+		sitemaps.Set([]byte(root), []byte(strconv.FormatInt(time.Now().Unix(), 10)))
 	}
+}
 
-	return theurl
+//whether two urls are on the same host, ignoring case and a leading www.
+func sameHost(a string, b string) bool {
+	ua, err := url.Parse(a)
+	if err!=nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err!=nil {
+		return false
+	}
+	ha := strings.TrimPrefix(strings.ToLower(ua.Host), "www.")
+	hb := strings.TrimPrefix(strings.ToLower(ub.Host), "www.")
+	return ha!="" && ha==hb
+}
+
+//elements whose text isnt part of the page content
+var boilerplate = map[string]bool{
+	"script": true, "style": true, "noscript": true, "template": true, "svg": true,
+	"iframe": true, "nav": true, "header": true, "footer": true, "aside": true,
+}
+
+//visible text of a page and its keywords, built up as it is scraped
+type pageText struct {
+	skip int //depth inside boilerplate elements
+	body strings.Builder
+
+	doc   uint64
+	terms *posting.Batch
+}
+
+//start a page, anything previously indexed for it is replaced once its terms are written
+func newPageText(index *posting.Index, theurl string) *pageText {
+	doc, _ := index.DocID(theurl, true)
+	page := &pageText{doc: doc, terms: posting.NewBatch()}
+	page.terms.Replace(doc)
+	return page
+}
+
+func (page *pageText) add(text string) {
+	if page.skip==0 {
+		page.body.WriteString(text)
+		page.body.WriteString(" ")
+	}
 }
 
 //Grabs Urls, keywords from token attributes, data, etc
 //adds urls to queue, keywords to index
 func scrapeToken(token html.Token, tokenizer *html.Tokenizer, urlo string, queue *gkvlite.Collection, 
-						index *gkvlite.Collection, meta *gkvlite.Collection, title *gkvlite.Collection) {
+						index *posting.Index, meta *gkvlite.Collection, title *gkvlite.Collection, links *gkvlite.Collection, page *pageText) {
 	switch token.Type {
         case html.StartTagToken: // <tag>
+        	if boilerplate[token.Data] {
+        		page.skip++
+        	}
+
         	if token.Data == "a" {
         		href:=""
         		linktext:=""
-        		_=linktext
 
         		nextType:=tokenizer.Next()
         		if nextType==html.TextToken {
         			linktext=tokenizer.Token().Data
+        			page.add(linktext)
         		}
 
         		for i:=0; i<len(token.Attr); i++ {
@@ -351,20 +1083,18 @@ func scrapeToken(token html.Token, tokenizer *html.Tokenizer, urlo string, queue
         				href = token.Attr[i].Val
         				if strings.Contains(href, ":") {
         					if strings.Contains(href, "http") {
-        						//queue.Set([]byte(href), []byte(""))
-        						queueAndCleanUrl(href, queue)
-        						//fmt.Println("Queueing "+cleaned)
-        						//addKeywords(href, linktext, index)
+        						cleaned := queueAndCleanUrl(href, queue)
+        						addReferrer(cleaned, urlo, links)
+        						addAnchorText(href, cleaned, urlo, linktext, index, page)
         					}
         				} else {
         					u, err := url.Parse(urlo)
         					if err==nil {
         						u, err = u.Parse(href)
         						if err==nil {
-	        						//queue.Set([]byte(u.String()), []byte(""))
-	        						queueAndCleanUrl(u.String(), queue)
-		        					//fmt.Println("Queueing "+cleaned)  
-		        					//addKeywords(url+href, linktext, index)
+	        						cleaned := queueAndCleanUrl(u.String(), queue)
+	        						addReferrer(cleaned, urlo, links)
+	        						addAnchorText(u.String(), cleaned, urlo, linktext, index, page)
 		        				}
         					}
         				}
@@ -379,85 +1109,76 @@ func scrapeToken(token html.Token, tokenizer *html.Tokenizer, urlo string, queue
         			} else if token.Attr[i].Key=="content" && use {
         				text := token.Attr[i].Val
         				meta.Set([]byte(urlo), []byte(text))
-        				addKeywords(urlo, text, index)
+        				addKeywords(page.terms, page.doc, text, posting.Meta)
         			}
 
         		}
 
-        	} else if token.Data == "title" || token.Data == "h1" || token.Data == "h2" || token.Data == "strong" {
+        	} else if token.Data == "title" || token.Data == "h1" || token.Data == "h2" || token.Data == "h3" || token.Data == "strong" {
 				nextType:=tokenizer.Next()
         		if nextType==html.TextToken {
         			eltext:=tokenizer.Token().Data
         			if token.Data == "title" {
         				title.Set([]byte(urlo), []byte(eltext))
+        				addKeywords(page.terms, page.doc, eltext, posting.Title)
+        			} else {
+        				page.add(eltext)
+        				if token.Data != "strong" {
+        					addKeywords(page.terms, page.doc, eltext, posting.Heading)
+        				}
         			}
-        			addKeywords(urlo, eltext, index)
         		}  
 
         	}
 
         case html.TextToken: // text
+        	page.add(token.Data)
+
         	if strings.Index(token.Data, "http://")==0 || strings.Index(token.Data, "https://")==0 {
 				//queue.Set([]byte(token.Data), []byte(""))
-        		queueAndCleanUrl(token.Data, queue)
+        		addReferrer(queueAndCleanUrl(token.Data, queue), urlo, links)
 				//fmt.Println("Queueing "+cleaned)
 			} else if strings.Index(token.Data, "www.")==0 {
 				//queue.Set([]byte("http://"+token.Data), []byte(""))
-				queueAndCleanUrl("http://"+token.Data, queue)
+				addReferrer(queueAndCleanUrl("http://"+token.Data, queue), urlo, links)
 				//fmt.Println("Queueing "+cleaned)
 			}
 
         case html.EndTagToken: // </tag>
+        	if boilerplate[token.Data] && page.skip>0 {
+        		page.skip--
+        	}
+
         case html.SelfClosingTagToken: // <tag/>
     }
 }
 
-//extract and add qualified keywords to index
-func addKeywords(urlo string, keywordtext string, index *gkvlite.Collection) {
-	//remove non-alphanumerics, non-space chars for spaces
-	reg, _ := regexp.Compile("[^a-zA-Z0-9 ]")
-	keywordtext = string(reg.ReplaceAll([]byte(keywordtext), []byte(" ")))
-
-	//split and loop
-	keywords := strings.Split(strings.ToLower(keywordtext), " ")
+//extract and add qualified keywords to the batch for doc, noting which field of the page they came from
+func addKeywords(terms *posting.Batch, doc uint64, keywordtext string, field posting.Field) {
+	//split into folded, stemmed terms. skipped words still take up a position so phrases line up
+	keywords := tokenizer.Terms(keywordtext)
 	for i:=0; i<len(keywords); i++ {
-		if len(keywords[i])>2 {
-			switch keywords[i] {
-				//ignored keywords
-				case "and", "the", "not":
-
-				//got this far? add em
-				default:
-					//todo: come up with better storage mechanism
-					list, err := index.Get([]byte(keywords[i]))
-					if err!=nil {
-						index.Set([]byte(keywords[i]), []byte(urlo+"||||"))
-						fmt.Println("Keyword: "+keywords[i])
-					} else {
-						urls := strings.Split(string(list), "||||")
-						add:= true
-						for j:=0; j<len(urls); j++ {
-							if urls[j]==urlo {
-								add = false
-							}
-						}
-						if add {
-							index.Set([]byte(keywords[i]), []byte(string(list)+urlo+"||||"))
-							fmt.Println("Keyword: "+keywords[i])
-						}
-					}
-			}
+		if keywords[i]!="" {
+			terms.Add(doc, keywords[i], field)
+		} else {
+			terms.Skip(doc, 1)
 		}
 	}
+	terms.Skip(doc, posting.FieldGap)
 }
 
 //Handle the few command line options logic
-func handleCommandLine(args []string, queue *gkvlite.Collection, log *gkvlite.Collection, index *gkvlite.Collection, meta *gkvlite.Collection, title *gkvlite.Collection) bool {
+func handleCommandLine(args []string, queue *gkvlite.Collection, log *gkvlite.Collection, index *posting.Index, meta *gkvlite.Collection, title *gkvlite.Collection) bool {
 	if args[0]=="help" {
 
 		fmt.Println("Usage: crawler [command]\nUsage: crawler [url url ...]")
 		fmt.Println("Defaults - Only crawl domains and subdomain index pages. Use all-urls command to change.")
-		fmt.Println("Commands: start-http start-https all-urls compact-db list-queue list-log list-index list-meta list-keywords list-titles clear-queue clear-log")
+		fmt.Println("Flags: -host-delay=1s -host-concurrency=1 -threads=10 -fail-threshold=5 -backoff=5m -max-backoff=24h -retry-backoff=1m -index-text")
+		fmt.Println("Stopwords: -stopwords=./stopwords -stopword-langs=en,fr -no-stopwords")
+		fmt.Println("Http: -config=file.json -user-agent -connect-timeout=10s -read-timeout=15s -timeout=60s -max-body=10485760 -max-redirects=5 -accept-encoding=gzip,deflate")
+		fmt.Println("Search: -search-addr=:8888 -search-read-timeout=15s -search-write-timeout=30s -cert=cert.pem -key=key.pem -auto-cert -cert-hosts=localhost,127.0.0.1")
+		fmt.Println("Certs: crawler gen-cert -host=localhost,127.0.0.1 -cert=cert.pem -key=key.pem -duration=8760h -ecdsa-curve=P256 -rsa-bits=2048 -ca")
+		fmt.Println("Commands: start-http start-https gen-cert all-urls compact-db list-queue list-log list-index list-meta list-keywords list-titles list-blocked list-sitemap list-broken list-blacklist unblock migrate-index clear-queue clear-log")
 		return true
 
 	} else if args[0]=="compact-db" {
@@ -476,6 +1197,7 @@ func handleCommandLine(args []string, queue *gkvlite.Collection, log *gkvlite.Co
 
 		fmt.Println("Clearing Log\n--------------")
 		store.RemoveCollection("scan-log")
+		store.RemoveCollection("http-cache") //otherwise cleared urls come back 304 and never reindex
 		store.Flush()
 		return true
 
@@ -491,8 +1213,13 @@ func handleCommandLine(args []string, queue *gkvlite.Collection, log *gkvlite.Co
   	} else if args[0]=="list-index" {
 	
 		fmt.Println("Current Index\n--------------")
-		index.VisitItemsAscend([]byte(""), true, func(i *gkvlite.Item) bool {
-		    fmt.Println(string(i.Key)+" : "+string(i.Val))
+		index.Keywords.VisitItemsAscend([]byte(""), true, func(i *gkvlite.Item) bool {
+		    postings, _ := posting.Decode(i.Val)
+		    urls := make([]string, 0, len(postings))
+		    for _, p := range postings {
+		    	urls = append(urls, index.URL(p.Doc)+" ("+strconv.Itoa(int(p.Freq))+")")
+		    }
+		    fmt.Println(string(i.Key)+" : "+strings.Join(urls, ", "))
 		    return true
 		})
 		return true
@@ -518,12 +1245,104 @@ func handleCommandLine(args []string, queue *gkvlite.Collection, log *gkvlite.Co
 	} else if args[0]=="list-keywords" {
 	
 		fmt.Println("Current Keywords\n--------------")
-		index.VisitItemsAscend([]byte(""), true, func(i *gkvlite.Item) bool {
+		index.Keywords.VisitItemsAscend([]byte(""), true, func(i *gkvlite.Item) bool {
 		    fmt.Print(string(i.Key)+" ")
 		    return true
 		})
 		return true
 
+	} else if args[0]=="migrate-index" {
+
+		migrateIndex(index)
+		fmt.Println("Indexed hosts of", index.IndexHosts(), "docs")
+		store.Flush()
+		return true
+
+	} else if args[0]=="list-blocked" {
+
+		fmt.Println("Blocked by robots.txt\n--------------")
+		blocked := store.SetCollection("robots-blocked", nil)
+		blocked.VisitItemsAscend([]byte(""), true, func(i *gkvlite.Item) bool {
+		    t, _ := strconv.ParseInt(string(i.Val), 10, 64)
+		    gt := time.Unix(t, 0)
+		    fmt.Println(string(i.Key)+" : "+gt.String())
+		    return true
+		})
+		return true
+
+	} else if args[0]=="list-sitemap" {
+
+		fmt.Println("Sitemap Urls (lastmod, priority)\n--------------")
+		sitemapurls := store.SetCollection("sitemap-urls", nil)
+		sitemapurls.VisitItemsAscend([]byte(""), true, func(i *gkvlite.Item) bool {
+		    parts := strings.Split(string(i.Val), "\t")
+		    fmt.Println(string(i.Key)+" : "+strings.Join(parts, ", "))
+		    return true
+		})
+		return true
+
+	} else if args[0]=="list-broken" {
+
+		fmt.Println("Broken Links\n--------------")
+		status := store.SetCollection("fetch-status", nil)
+		links := store.SetCollection("links", nil)
+		status.VisitItemsAscend([]byte(""), true, func(i *gkvlite.Item) bool {
+		    //code, final url, error class, time
+		    parts := strings.Split(string(i.Val), "\t")
+		    if len(parts)<4 {
+		    	return true
+		    }
+		    code, _ := strconv.Atoi(parts[0])
+		    if code!=404 && code!=410 && !fetchFailed(code) {
+		    	return true
+		    }
+
+		    reason := parts[0]
+		    if parts[2]!="" {
+		    	reason = parts[2]
+		    }
+		    t, _ := strconv.ParseInt(parts[3], 10, 64)
+		    line := string(i.Key)+" : "+reason+" : "+time.Unix(t, 0).String()
+		    if len(parts)>=6 && fetchFailed(code) {
+		    	r, _ := strconv.ParseInt(parts[5], 10, 64)
+		    	line += " : failed "+parts[4]+" times, retrying after "+time.Unix(r, 0).String()
+		    }
+		    fmt.Println(line)
+
+		    refs, err := links.Get(i.Key)
+		    if err==nil && refs!=nil {
+		    	for _, ref := range strings.Split(string(refs), "\n") {
+		    		fmt.Println("    linked from "+ref)
+		    	}
+		    }
+		    return true
+		})
+		return true
+
+	} else if args[0]=="list-blacklist" {
+
+		fmt.Println("Blacklisted Hosts\n--------------")
+		for _, h := range hostHealth.List() {
+			fmt.Println(h.Name+" : until "+h.Until.String()+" (strike "+strconv.Itoa(h.Strikes)+")")
+		}
+		return true
+
+	} else if args[0]=="unblock" {
+
+		if len(args)<2 {
+			fmt.Println("Usage: crawler unblock host [host ...]")
+			return true
+		}
+		for _, host := range args[1:] {
+			if hostHealth.Unblock(host) {
+				fmt.Println("Unblocked "+health.HostOf(host))
+			} else {
+				fmt.Println("Not blacklisted: "+health.HostOf(host))
+			}
+		}
+		store.Flush()
+		return true
+
 	} else if args[0]=="list-log" {
 		
 		fmt.Println("Current Log\n--------------")
@@ -540,6 +1359,51 @@ func handleCommandLine(args []string, queue *gkvlite.Collection, log *gkvlite.Co
 	return false
 }
 
+//Convert keyword postings from the old "url||||url||||" strings to binary posting lists
+func migrateIndex(index *posting.Index) {
+	fmt.Print("Migrating index...")
+
+	//collect first, dont modify while visiting
+	batch := posting.NewBatch()
+	old := []string{}
+	index.Keywords.VisitItemsAscend([]byte(""), true, func(i *gkvlite.Item) bool {
+		if !strings.HasSuffix(string(i.Val), "||||") {
+			return true
+		}
+		old = append(old, string(i.Key))
+
+		entries := strings.Split(string(i.Val), "||||")
+		for j:=0; j<len(entries)-1; j++ { //-1 for the extra |||| at the end
+			//may carry a tab and field mask
+			parts := strings.SplitN(entries[j], "\t", 2)
+			field := posting.Body
+			if len(parts)>1 {
+				f, err := strconv.Atoi(parts[1])
+				if err==nil && f>0 {
+					field = posting.Field(f)
+				}
+			}
+			doc, _ := index.DocID(parts[0], true)
+			batch.Replace(doc) //their own words, not anchor text
+			batch.Add(doc, string(i.Key), field)
+		}
+		return true
+	})
+
+	for _, k := range old {
+		index.Keywords.Delete([]byte(k))
+	}
+	err := index.Write(batch)
+	if err!=nil {
+		//something still doesnt decode, dont mark it migrated
+		fmt.Println("Err-Index: ", err)
+		return
+	}
+	index.SetCurrent()
+
+	fmt.Println("Done.", len(old), "keywords")
+}
+
 //Compact the gkv store
 func compactDb() {
 	fmt.Print("Compacting db...")
@@ -0,0 +1,97 @@
+package extract
+
+/*
+	Link extraction from non-html responses.
+	Plain text only yields absolute urls, css and javascript also yield
+	relative ones that can be resolved against the document's url.
+*/
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var absoluteRe = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s"'<>()\[\]{}\\` + "`" + `]+`)
+
+//url(...) and @import "..." in stylesheets
+var cssUrlRe = regexp.MustCompile(`(?i)url\(\s*["']?([^"')\s]+)["']?\s*\)`)
+var cssImportRe = regexp.MustCompile(`(?i)@import\s+["']([^"']+)["']`)
+
+//quoted strings in scripts that look like paths
+var jsPathRe = regexp.MustCompile(`["'` + "`" + `]((?:https?:)?//[^"'` + "`" + `\s]+|\.{0,2}/[^"'` + "`" + `\s]+)["'` + "`" + `]`)
+
+//Text pulls absolute urls (and bare www. hosts) out of plain text
+func Text(body string) []string {
+	found := []string{}
+	for _, m := range absoluteRe.FindAllString(body, -1) {
+		//sentence punctuation is more likely than a url ending in it
+		m = strings.TrimRight(m, ".,;:!?")
+		if strings.HasPrefix(strings.ToLower(m), "www.") {
+			m = "http://" + m
+		}
+		if u, err := url.Parse(m); err == nil && u.Host != "" {
+			found = append(found, u.String())
+		}
+	}
+	return dedupe(found)
+}
+
+//CSS pulls url(...) and @import targets, resolved against base
+func CSS(body string, base string) []string {
+	found := []string{}
+	for _, m := range cssUrlRe.FindAllStringSubmatch(body, -1) {
+		found = append(found, m[1])
+	}
+	for _, m := range cssImportRe.FindAllStringSubmatch(body, -1) {
+		found = append(found, m[1])
+	}
+	return resolve(found, base)
+}
+
+//JavaScript pulls absolute urls and quoted path-like strings, resolved against base
+func JavaScript(body string, base string) []string {
+	found := []string{}
+	for _, m := range jsPathRe.FindAllStringSubmatch(body, -1) {
+		p := m[1]
+		//regex literals and comments like "//" or "/" on their own arent links
+		if len(strings.Trim(p, "/.")) == 0 {
+			continue
+		}
+		found = append(found, p)
+	}
+	found = append(found, Text(body)...)
+	return resolve(found, base)
+}
+
+func resolve(found []string, base string) []string {
+	b, err := url.Parse(base)
+	if err != nil {
+		return nil
+	}
+
+	resolved := []string{}
+	for _, f := range found {
+		if strings.HasPrefix(f, "data:") || strings.HasPrefix(f, "#") {
+			continue
+		}
+		u, err := b.Parse(f)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			continue
+		}
+		resolved = append(resolved, u.String())
+	}
+	return dedupe(resolved)
+}
+
+func dedupe(urls []string) []string {
+	seen := map[string]bool{}
+	out := []string{}
+	for _, u := range urls {
+		if !seen[u] {
+			seen[u] = true
+			out = append(out, u)
+		}
+	}
+	return out
+}
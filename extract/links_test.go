@@ -0,0 +1,74 @@
+package extract
+
+import (
+	"reflect"
+	"testing"
+)
+
+const base = "http://example.com/css/site.css"
+
+func TestText(t *testing.T) {
+	tests := []struct {
+		body string
+		want []string
+	}{
+		{"", []string{}},
+		{"see http://a.com/x.", []string{"http://a.com/x"}},
+		{"(https://a.com/y), and www.b.com!", []string{"https://a.com/y", "http://www.b.com"}},
+		{"twice http://a.com http://a.com", []string{"http://a.com"}},
+		{"relative /path and ftp://c.com arent", []string{}},
+		{`"http://a.com/q?x=1"`, []string{"http://a.com/q?x=1"}},
+	}
+	for _, tt := range tests {
+		if got := Text(tt.body); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Text(%q) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestCSS(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"url", `a{background:url(img/bg.png)}`, []string{"http://example.com/css/img/bg.png"}},
+		{"quoted url", `a{background:URL( "../img/bg.png" )}`, []string{"http://example.com/img/bg.png"}},
+		{"root relative", `a{background:url('/bg.png')}`, []string{"http://example.com/bg.png"}},
+		{"absolute", `a{background:url(https://cdn.com/x.png)}`, []string{"https://cdn.com/x.png"}},
+		{"import", `@import "other.css"; @import url(more.css);`, []string{"http://example.com/css/more.css", "http://example.com/css/other.css"}},
+		{"data skipped", `a{background:url(data:image/png;base64,AAAA)}`, []string{}},
+		{"fragment skipped", `a{filter:url(#blur)}`, []string{}},
+		{"duplicates", `a{background:url(x.png)} b{background:url(x.png)}`, []string{"http://example.com/css/x.png"}},
+	}
+	for _, tt := range tests {
+		if got := CSS(tt.body, base); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: CSS = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestJavaScript(t *testing.T) {
+	const jsBase = "https://example.com/js/app.js"
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"relative paths", `fetch("/api/items"); load('./mod.js'); load("../lib/x.js")`,
+			[]string{"https://example.com/api/items", "https://example.com/js/mod.js", "https://example.com/lib/x.js"}},
+		{"protocol relative", "src = `//cdn.com/lib.js`", []string{"https://cdn.com/lib.js"}},
+		{"absolute", `var u = "http://other.com/page"`, []string{"http://other.com/page"}},
+		{"not paths", `x = "/"; y = "//"; z = "hello"; re = /a\/b/`, []string{}},
+		{"data skipped", `img.src = "data:image/png;base64,AAAA"`, []string{}},
+	}
+	for _, tt := range tests {
+		if got := JavaScript(tt.body, jsBase); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: JavaScript = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	if got := JavaScript(`"/x"`, "::not a url"); got != nil {
+		t.Errorf("bad base gave %q", got)
+	}
+}
@@ -0,0 +1,264 @@
+package fetcher
+
+/*
+	The crawler's http client.
+	Timeouts, body size caps, redirect policy, user agent and accepted
+	encodings are set through a Config, from flags or a json config file.
+*/
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//Config for the crawler's http client
+type Config struct {
+	UserAgent      string
+	ConnectTimeout time.Duration //dial and tls handshake
+	ReadTimeout    time.Duration //waiting on response headers
+	Timeout        time.Duration //whole request including the body, 0 for none
+	MaxBodyBytes   int64         //bodies are cut off past this with ErrTruncated, 0 for no limit
+	MaxRedirects   int           //0 to hand redirects back instead of following
+	AcceptEncoding string        //ie "gzip, deflate". empty leaves it to net/http
+	Accept         string
+}
+
+//DefaultConfig is what the crawler runs with when nothing is set
+func DefaultConfig() Config {
+	return Config{
+		UserAgent:      "gofish/0.1 (+https://github.com/blamarche/gofish)",
+		ConnectTimeout: 10 * time.Second,
+		ReadTimeout:    15 * time.Second,
+		Timeout:        60 * time.Second,
+		MaxBodyBytes:   10 * 1024 * 1024,
+		MaxRedirects:   5,
+		AcceptEncoding: "gzip, deflate",
+		Accept:         "text/html,application/xhtml+xml,text/plain;q=0.9,*/*;q=0.5",
+	}
+}
+
+//LoadConfig overlays the settings in a json file onto c. Durations are strings like "10s".
+func LoadConfig(path string, c *Config) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	raw := struct {
+		UserAgent      *string
+		ConnectTimeout *string
+		ReadTimeout    *string
+		Timeout        *string
+		MaxBodyBytes   *int64
+		MaxRedirects   *int
+		AcceptEncoding *string
+		Accept         *string
+	}{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	durations := []struct {
+		val *string
+		dst *time.Duration
+	}{
+		{raw.ConnectTimeout, &c.ConnectTimeout},
+		{raw.ReadTimeout, &c.ReadTimeout},
+		{raw.Timeout, &c.Timeout},
+	}
+	for _, d := range durations {
+		if d.val == nil {
+			continue
+		}
+		t, err := time.ParseDuration(*d.val)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		*d.dst = t
+	}
+
+	if raw.UserAgent != nil {
+		c.UserAgent = *raw.UserAgent
+	}
+	if raw.MaxBodyBytes != nil {
+		c.MaxBodyBytes = *raw.MaxBodyBytes
+	}
+	if raw.MaxRedirects != nil {
+		c.MaxRedirects = *raw.MaxRedirects
+	}
+	if raw.AcceptEncoding != nil {
+		c.AcceptEncoding = *raw.AcceptEncoding
+	}
+	if raw.Accept != nil {
+		c.Accept = *raw.Accept
+	}
+	return nil
+}
+
+//ErrTruncated is returned reading past MaxBodyBytes of a body, after the bytes up to the limit
+var ErrTruncated = errors.New("body truncated at max-body")
+
+//NewClient built from the config, every request made with it gets the config applied
+func NewClient(c Config) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   c.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   c.ConnectTimeout,
+		ResponseHeaderTimeout: c.ReadTimeout,
+		MaxIdleConnsPerHost:   2,
+		IdleConnTimeout:       90 * time.Second,
+		//we do our own so the accepted encodings are up to the config
+		DisableCompression: c.AcceptEncoding != "",
+	}
+
+	return &http.Client{
+		Transport: &roundTripper{base: transport, config: c},
+		Timeout:   c.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if c.MaxRedirects <= 0 {
+				return http.ErrUseLastResponse
+			}
+			if len(via) > c.MaxRedirects {
+				return fmt.Errorf("stopped after %d redirects", c.MaxRedirects)
+			}
+			return nil
+		},
+	}
+}
+
+type roundTripper struct {
+	base   http.RoundTripper
+	config Config
+}
+
+func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	//dont modify the callers request
+	r := req.Clone(req.Context())
+	if r.Header.Get("User-Agent") == "" && rt.config.UserAgent != "" {
+		r.Header.Set("User-Agent", rt.config.UserAgent)
+	}
+	if r.Header.Get("Accept") == "" && rt.config.Accept != "" {
+		r.Header.Set("Accept", rt.config.Accept)
+	}
+	if rt.config.AcceptEncoding != "" {
+		r.Header.Set("Accept-Encoding", rt.config.AcceptEncoding)
+	}
+
+	resp, err := rt.base.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if rt.config.AcceptEncoding != "" {
+		if err := decode(resp); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	}
+	if rt.config.MaxBodyBytes > 0 {
+		resp.Body = &limitedBody{ReadCloser: resp.Body, left: rt.config.MaxBodyBytes}
+	}
+	return resp, nil
+}
+
+//swap in a decompressing body for the encodings we asked for
+func decode(resp *http.Response) error {
+	enc := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+
+	switch enc {
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			//empty bodies (ie 304s) still carry the header
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		resp.Body = &decodedBody{Reader: gz, orig: resp.Body}
+
+	case "deflate":
+		//http deflate is meant to be zlib wrapped but some servers send it raw
+		br := bufio.NewReader(resp.Body)
+		header, err := br.Peek(2)
+		if len(header) == 0 && err == io.EOF {
+			return nil
+		}
+		if isZlib(header) {
+			zr, err := zlib.NewReader(br)
+			if err != nil {
+				return err
+			}
+			resp.Body = &decodedBody{Reader: zr, orig: resp.Body}
+		} else {
+			resp.Body = &decodedBody{Reader: flate.NewReader(br), orig: resp.Body}
+		}
+
+	default:
+		return nil
+	}
+
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return nil
+}
+
+//zlib header: deflate method and a check sum of the two bytes divisible by 31
+func isZlib(header []byte) bool {
+	if len(header) < 2 {
+		return false
+	}
+	return header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0
+}
+
+type decodedBody struct {
+	io.Reader
+	orig io.ReadCloser
+}
+
+func (b *decodedBody) Close() error {
+	if c, ok := b.Reader.(io.Closer); ok {
+		c.Close()
+	}
+	return b.orig.Close()
+}
+
+type limitedBody struct {
+	io.ReadCloser
+	left int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.left <= 0 {
+		//only an error if there was more to read
+		var probe [1]byte
+		n, err := b.ReadCloser.Read(probe[:])
+		if n > 0 {
+			return 0, ErrTruncated
+		}
+		return 0, err
+	}
+	if int64(len(p)) > b.left {
+		p = p[:b.left]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.left -= int64(n)
+	return n, err
+}
@@ -0,0 +1,120 @@
+package fetcher
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func gzipped(s string) []byte {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	w.Write([]byte(s))
+	w.Close()
+	return b.Bytes()
+}
+
+func zlibbed(s string) []byte {
+	var b bytes.Buffer
+	w := zlib.NewWriter(&b)
+	w.Write([]byte(s))
+	w.Close()
+	return b.Bytes()
+}
+
+func rawDeflated(s string) []byte {
+	var b bytes.Buffer
+	w, _ := flate.NewWriter(&b, flate.DefaultCompression)
+	w.Write([]byte(s))
+	w.Close()
+	return b.Bytes()
+}
+
+func TestDecode(t *testing.T) {
+	const page = "<html><body>hello, compressed world</body></html>"
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+		want     string
+	}{
+		{"identity", "", []byte(page), page},
+		{"gzip", "gzip", gzipped(page), page},
+		{"x-gzip", "x-gzip", gzipped(page), page},
+		{"zlib deflate", "deflate", zlibbed(page), page},
+		{"raw deflate", "deflate", rawDeflated(page), page},
+		{"upper case", "GZIP", gzipped(page), page},
+		{"empty gzip", "gzip", nil, ""},
+		{"empty deflate", "deflate", nil, ""},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{
+			Header: http.Header{},
+			Body:   ioutil.NopCloser(bytes.NewReader(tt.body)),
+		}
+		if tt.encoding != "" {
+			resp.Header.Set("Content-Encoding", tt.encoding)
+		}
+		if err := decode(resp); err != nil {
+			t.Errorf("%s: decode: %v", tt.name, err)
+			continue
+		}
+		got, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Errorf("%s: read: %v", tt.name, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIsZlib(t *testing.T) {
+	if !isZlib(zlibbed("x")[:2]) {
+		t.Error("zlib header not recognized")
+	}
+	//raw deflate blocks can start with an 8 in the low bits but fail the check sum
+	if isZlib([]byte{0x78, 0x00}) {
+		t.Error("bad check sum accepted")
+	}
+	if isZlib([]byte{0x78}) {
+		t.Error("short header accepted")
+	}
+}
+
+func TestMaxBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(strings.Repeat("a", 100)))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		max     int64
+		wantLen int
+		wantErr error
+	}{
+		{0, 100, nil},
+		{50, 50, ErrTruncated},
+		{100, 100, nil},
+		{200, 100, nil},
+	}
+	for _, tt := range tests {
+		c := DefaultConfig()
+		c.MaxBodyBytes = tt.max
+		resp, err := NewClient(c).Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if len(body) != tt.wantLen || err != tt.wantErr {
+			t.Errorf("max %d: read %d bytes, err %v, want %d bytes, err %v", tt.max, len(body), err, tt.wantLen, tt.wantErr)
+		}
+	}
+}
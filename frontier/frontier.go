@@ -0,0 +1,219 @@
+package frontier
+
+/*
+	Host aware crawl frontier.
+	Urls are held in per-host queues and handed out round robin across hosts,
+	never running more than MaxPerHost requests against one host at a time and
+	waiting at least the host's delay between requests to it.
+*/
+
+import (
+	"net/url"
+	"sync"
+	"time"
+)
+
+type host struct {
+	name   string
+	urls   []string
+	active int
+	next   time.Time     //earliest time the next request may start
+	delay  time.Duration //looked up once when the host is added
+}
+
+//Frontier of urls waiting to be requested
+type Frontier struct {
+	MinDelay   time.Duration
+	MaxPerHost int
+
+	//optional per-url delay lookup, ie robots.txt crawl-delay. the larger of this and MinDelay is used.
+	//called without the frontier locked, once per host while it has urls waiting or in flight
+	DelayFunc func(theurl string) time.Duration
+
+	mu    sync.Mutex
+	cond  *sync.Cond
+	hosts map[string]*host
+	ring  []*host
+	pos   int
+	seen  map[string]bool
+	count int
+}
+
+//New frontier with the given per-host delay and concurrency
+func New(minDelay time.Duration, maxPerHost int) *Frontier {
+	if maxPerHost < 1 {
+		maxPerHost = 1
+	}
+	f := &Frontier{
+		MinDelay:   minDelay,
+		MaxPerHost: maxPerHost,
+		hosts:      map[string]*host{},
+		seen:       map[string]bool{},
+	}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+func hostOf(theurl string) string {
+	u, err := url.Parse(theurl)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+//Push a url onto its host's queue. Returns false if it is already waiting or in flight.
+func (f *Frontier) Push(theurl string) bool {
+	name := hostOf(theurl)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.seen[theurl] {
+		return false
+	}
+
+	h, ok := f.hosts[name]
+	if !ok {
+		//the delay lookup can hit the network, dont hold up every other thread on it
+		f.mu.Unlock()
+		delay := f.delay(theurl)
+		f.mu.Lock()
+
+		if f.seen[theurl] {
+			return false
+		}
+		h, ok = f.hosts[name]
+		if !ok {
+			h = &host{name: name, delay: delay}
+			f.hosts[name] = h
+			f.ring = append(f.ring, h)
+		}
+	}
+	f.seen[theurl] = true
+	h.urls = append(h.urls, theurl)
+	f.count++
+
+	f.cond.Broadcast()
+	return true
+}
+
+//Len is the number of urls waiting to be handed out
+func (f *Frontier) Len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.count
+}
+
+//Next blocks until a url is ready to be requested and returns it.
+//Callers must call Done once the request is finished.
+func (f *Frontier) Next() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for {
+		now := time.Now()
+		var wait time.Duration = -1
+
+		//round robin from where we left off
+		for n := 0; n < len(f.ring); n++ {
+			i := (f.pos + n) % len(f.ring)
+			h := f.ring[i]
+			if len(h.urls) == 0 || h.active >= f.MaxPerHost {
+				continue
+			}
+			if now.Before(h.next) {
+				if d := h.next.Sub(now); wait < 0 || d < wait {
+					wait = d
+				}
+				continue
+			}
+
+			theurl := h.urls[0]
+			h.urls = h.urls[1:]
+			h.active++
+			h.next = now.Add(h.delay)
+			f.count--
+			f.pos = (i + 1) % len(f.ring)
+			return theurl
+		}
+
+		//nothing ready, sleep until the soonest host opens up or something changes
+		if wait >= 0 {
+			t := time.AfterFunc(wait, f.cond.Broadcast)
+			f.cond.Wait()
+			t.Stop()
+		} else {
+			f.cond.Wait()
+		}
+	}
+}
+
+//Done releases the host slot taken by Next. The url stays marked as seen until Forget.
+func (f *Frontier) Done(theurl string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	h, ok := f.hosts[hostOf(theurl)]
+	if !ok {
+		return
+	}
+	h.active--
+
+	//measure the delay from the end of the request as well as the start
+	if next := time.Now().Add(h.delay); next.After(h.next) {
+		h.next = next
+	}
+
+	f.prune(h)
+	f.cond.Broadcast()
+}
+
+//Forget allows a url to be pushed again, once it has been logged or given up on
+func (f *Frontier) Forget(theurl string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.seen, theurl)
+}
+
+func (f *Frontier) delay(theurl string) time.Duration {
+	d := f.MinDelay
+	if f.DelayFunc != nil {
+		if cd := f.DelayFunc(theurl); cd > d {
+			d = cd
+		}
+	}
+	return d
+}
+
+//drop idle hosts from the ring once they can no longer affect politeness
+func (f *Frontier) prune(h *host) {
+	if len(h.urls) > 0 || h.active > 0 {
+		return
+	}
+	//keep around until the delay has passed, otherwise a new push could skip it
+	if time.Now().Before(h.next) {
+		time.AfterFunc(h.next.Sub(time.Now()), func() {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			f.prune(h)
+		})
+		return
+	}
+	if f.hosts[h.name] != h {
+		return
+	}
+	delete(f.hosts, h.name)
+	for i, r := range f.ring {
+		if r == h {
+			f.ring = append(f.ring[:i], f.ring[i+1:]...)
+			if f.pos > i {
+				f.pos--
+			}
+			if f.pos >= len(f.ring) {
+				f.pos = 0
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package frontier
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRoundRobin(t *testing.T) {
+	f := New(0, 1)
+	for _, u := range []string{"http://a/1", "http://a/2", "http://b/1", "http://c/1"} {
+		if !f.Push(u) {
+			t.Fatalf("Push(%q) = false", u)
+		}
+	}
+	if f.Push("http://a/1") {
+		t.Error("pushed a waiting url twice")
+	}
+
+	want := []string{"http://a/1", "http://b/1", "http://c/1", "http://a/2"}
+	for _, w := range want {
+		got := f.Next()
+		if got != w {
+			t.Errorf("Next() = %q, want %q", got, w)
+		}
+		f.Done(got)
+	}
+	if f.Len() != 0 {
+		t.Errorf("Len() = %d, want 0", f.Len())
+	}
+}
+
+func TestHostDelay(t *testing.T) {
+	f := New(0, 1)
+	f.DelayFunc = func(theurl string) time.Duration {
+		if strings.Contains(theurl, "://slow/") {
+			return 50 * time.Millisecond
+		}
+		return 0
+	}
+	f.Push("http://slow/1")
+	f.Push("http://slow/2")
+
+	start := time.Now()
+	f.Done(f.Next())
+	f.Done(f.Next())
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("second request after %v, want at least 50ms", elapsed)
+	}
+}
+
+func TestSlowDelayLookupDoesntBlock(t *testing.T) {
+	f := New(0, 1)
+	release := make(chan bool)
+	f.DelayFunc = func(theurl string) time.Duration {
+		if strings.Contains(theurl, "://slow/") {
+			<-release
+		}
+		return 0
+	}
+	defer close(release)
+
+	go f.Push("http://slow/1")
+	time.Sleep(10 * time.Millisecond)
+
+	done := make(chan string)
+	go func() {
+		f.Push("http://fast/1")
+		done <- f.Next()
+	}()
+	select {
+	case got := <-done:
+		if got != "http://fast/1" {
+			t.Errorf("Next() = %q, want http://fast/1", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("fast host waited on the slow host's delay lookup")
+	}
+}
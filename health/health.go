@@ -0,0 +1,182 @@
+package health
+
+/*
+	Per-host health tracking.
+	Consecutive failures against a host are counted and once past a threshold
+	the host is blacklisted for a cooldown that doubles each time it happens
+	again. State lives in a gkvlite collection so it survives restarts.
+*/
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/steveyen/gkvlite"
+)
+
+//Host state as stored
+type Host struct {
+	Name     string
+	Failures int       //consecutive failures since the last success
+	Strikes  int       //times blacklisted without a success in between
+	Until    time.Time //blacklisted until, zero if not
+}
+
+//Tracker of host failures and cooldowns
+type Tracker struct {
+	Threshold  int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	coll *gkvlite.Collection
+	mu   sync.Mutex
+}
+
+//NewTracker persisting to coll
+func NewTracker(coll *gkvlite.Collection, threshold int, backoff time.Duration, maxBackoff time.Duration) *Tracker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &Tracker{
+		Threshold:  threshold,
+		Backoff:    backoff,
+		MaxBackoff: maxBackoff,
+		coll:       coll,
+	}
+}
+
+//HostOf a url, or the string itself if it is already a bare host
+func HostOf(theurl string) string {
+	u, err := url.Parse(theurl)
+	if err != nil || u.Host == "" {
+		return strings.ToLower(strings.TrimRight(theurl, "/"))
+	}
+	return strings.ToLower(u.Host)
+}
+
+//stored as failures\tstrikes\tuntil
+func (t *Tracker) get(name string) *Host {
+	h := &Host{Name: name}
+	val, err := t.coll.Get([]byte(name))
+	if err != nil || val == nil {
+		return h
+	}
+	parts := strings.Split(string(val), "\t")
+	if len(parts) < 3 {
+		return h
+	}
+	h.Failures, _ = strconv.Atoi(parts[0])
+	h.Strikes, _ = strconv.Atoi(parts[1])
+	if until, err := strconv.ParseInt(parts[2], 10, 64); err == nil && until > 0 {
+		h.Until = time.Unix(until, 0)
+	}
+	return h
+}
+
+func (t *Tracker) put(h *Host) {
+	if h.Failures == 0 && h.Strikes == 0 {
+		t.coll.Delete([]byte(h.Name))
+		return
+	}
+	until := int64(0)
+	if !h.Until.IsZero() {
+		until = h.Until.Unix()
+	}
+	val := strconv.Itoa(h.Failures) + "\t" + strconv.Itoa(h.Strikes) + "\t" + strconv.FormatInt(until, 10)
+	t.coll.Set([]byte(h.Name), []byte(val))
+}
+
+//Success against the url's host clears its failure count
+func (t *Tracker) Success(theurl string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	h := t.get(HostOf(theurl))
+	if h.Failures == 0 && h.Strikes == 0 {
+		return
+	}
+	h.Failures = 0
+	h.Strikes = 0
+	h.Until = time.Time{}
+	t.put(h)
+}
+
+//Failure against the url's host. Returns true if this put the host on the blacklist.
+func (t *Tracker) Failure(theurl string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	h := t.get(HostOf(theurl))
+	h.Failures++
+
+	blacklisted := false
+	if h.Failures >= t.Threshold {
+		h.Strikes++
+		h.Failures = 0
+		h.Until = time.Now().Add(t.cooldown(h.Strikes))
+		blacklisted = true
+	}
+	t.put(h)
+	return blacklisted
+}
+
+//cooldown doubles with each strike, up to MaxBackoff
+func (t *Tracker) cooldown(strikes int) time.Duration {
+	d := t.Backoff
+	for i := 1; i < strikes; i++ {
+		d *= 2
+		if t.MaxBackoff > 0 && d >= t.MaxBackoff {
+			return t.MaxBackoff
+		}
+	}
+	if t.MaxBackoff > 0 && d > t.MaxBackoff {
+		return t.MaxBackoff
+	}
+	return d
+}
+
+//Blocked reports whether the url's host is cooling down, and until when
+func (t *Tracker) Blocked(theurl string) (bool, time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	h := t.get(HostOf(theurl))
+	if h.Until.IsZero() || time.Now().After(h.Until) {
+		return false, time.Time{}
+	}
+	return true, h.Until
+}
+
+//Unblock a host, forgetting its history. Returns false if it wasnt tracked.
+func (t *Tracker) Unblock(host string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	name := HostOf(host)
+	val, err := t.coll.Get([]byte(name))
+	if err != nil || val == nil {
+		return false
+	}
+	t.coll.Delete([]byte(name))
+	return true
+}
+
+//List hosts currently blacklisted
+func (t *Tracker) List() []*Host {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	hosts := []*Host{}
+	now := time.Now()
+	t.coll.VisitItemsAscend([]byte(""), false, func(i *gkvlite.Item) bool {
+		h := t.get(string(i.Key))
+		if !h.Until.IsZero() && now.Before(h.Until) {
+			hosts = append(hosts, h)
+		}
+		return true
+	})
+	return hosts
+}
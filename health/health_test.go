@@ -0,0 +1,117 @@
+package health
+
+import (
+	"testing"
+	"time"
+
+	"github.com/steveyen/gkvlite"
+)
+
+func newTracker(threshold int, backoff time.Duration, maxBackoff time.Duration) *Tracker {
+	store, _ := gkvlite.NewStore(nil)
+	return NewTracker(store.SetCollection("host-health", nil), threshold, backoff, maxBackoff)
+}
+
+func TestHostOf(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"http://Example.com/a/b", "example.com"},
+		{"https://example.com:8443/", "example.com:8443"},
+		{"example.com", "example.com"},
+		{"Example.com/", "example.com"},
+	}
+	for _, tt := range tests {
+		if got := HostOf(tt.in); got != tt.want {
+			t.Errorf("HostOf(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCooldown(t *testing.T) {
+	tests := []struct {
+		maxBackoff time.Duration
+		strikes    int
+		want       time.Duration
+	}{
+		{5 * time.Minute, 1, time.Minute},
+		{5 * time.Minute, 2, 2 * time.Minute},
+		{5 * time.Minute, 3, 4 * time.Minute},
+		{5 * time.Minute, 4, 5 * time.Minute},
+		{5 * time.Minute, 50, 5 * time.Minute},
+		{30 * time.Second, 1, 30 * time.Second}, //capped even on the first strike
+		{0, 4, 8 * time.Minute},                 //no cap
+	}
+	for _, tt := range tests {
+		tr := newTracker(3, time.Minute, tt.maxBackoff)
+		if got := tr.cooldown(tt.strikes); got != tt.want {
+			t.Errorf("cooldown(%d) with max %v = %v, want %v", tt.strikes, tt.maxBackoff, got, tt.want)
+		}
+	}
+}
+
+func TestFailureThreshold(t *testing.T) {
+	tr := newTracker(3, time.Minute, time.Hour)
+	u := "http://example.com/page"
+
+	//each round of threshold failures blacklists for twice as long
+	for strike, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute} {
+		for i := 1; i <= 3; i++ {
+			blacklisted := tr.Failure(u)
+			if blacklisted != (i == 3) {
+				t.Fatalf("strike %d failure %d: blacklisted = %v", strike+1, i, blacklisted)
+			}
+		}
+		blocked, until := tr.Blocked("http://example.com/other")
+		if !blocked {
+			t.Fatalf("strike %d: not blocked", strike+1)
+		}
+		if d := time.Until(until); d < want-2*time.Second || d > want+time.Second {
+			t.Errorf("strike %d: blocked for %v, want %v", strike+1, d, want)
+		}
+	}
+	if blocked, _ := tr.Blocked("http://other.com/"); blocked {
+		t.Error("other host blocked")
+	}
+	if hosts := tr.List(); len(hosts) != 1 || hosts[0].Name != "example.com" || hosts[0].Strikes != 3 {
+		t.Errorf("List = %+v", hosts)
+	}
+
+	//a success starts over
+	tr.Success(u)
+	if blocked, _ := tr.Blocked(u); blocked {
+		t.Error("still blocked after a success")
+	}
+	tr.Failure(u)
+	tr.Failure(u)
+	if tr.Failure(u); tr.get("example.com").Strikes != 1 {
+		t.Errorf("strikes after a success %d, want 1", tr.get("example.com").Strikes)
+	}
+}
+
+func TestUnblock(t *testing.T) {
+	store, _ := gkvlite.NewStore(nil)
+	coll := store.SetCollection("host-health", nil)
+	tr := NewTracker(coll, 1, time.Hour, 0)
+	tr.Failure("http://example.com/")
+
+	//state is kept in the collection
+	again := NewTracker(coll, 1, time.Hour, 0)
+	if blocked, _ := again.Blocked("http://example.com/x"); !blocked {
+		t.Fatal("blacklist not persisted")
+	}
+
+	if !again.Unblock("Example.com") {
+		t.Error("Unblock of a blacklisted host = false")
+	}
+	if blocked, _ := again.Blocked("http://example.com/x"); blocked {
+		t.Error("still blocked after Unblock")
+	}
+	if again.Unblock("example.com") {
+		t.Error("second Unblock = true")
+	}
+	if len(again.List()) != 0 {
+		t.Errorf("List after Unblock = %+v", again.List())
+	}
+}
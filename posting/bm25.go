@@ -0,0 +1,103 @@
+package posting
+
+/*
+	BM25 scoring.
+	Term frequencies are weighted per field before saturation (BM25F), so a
+	word in the title counts for more than one in the body, and the total is
+	normalized by the doc's length against the average. Searching several
+	stores, N and the average length are taken over all of them so scores
+	from each can be compared.
+*/
+
+import (
+	"math"
+)
+
+//DefaultBoosts per field
+var DefaultBoosts = [NumFields]float64{
+	3,   //Title
+	2,   //Heading
+	1.5, //Meta
+	1,   //Body
+	2,   //Anchor
+}
+
+//Scorer for an index
+type Scorer struct {
+	N      float64 //docs with a length
+	AvgLen float64
+	K1     float64
+	B      float64
+	Boosts [NumFields]float64
+
+	ix *Index
+}
+
+//Scorer with the usual parameters and the index's current totals
+func (ix *Index) Scorer() *Scorer {
+	return CorpusScorer(ix).On(ix)
+}
+
+//CorpusScorer with the usual parameters and the totals of every index together.
+//Use On to get one for scoring docs of a particular index.
+func CorpusScorer(inds ...*Index) *Scorer {
+	docs, total := uint64(0), uint64(0)
+	for _, ix := range inds {
+		d, t := ix.Totals()
+		docs += d
+		total += t
+	}
+	s := &Scorer{N: float64(docs), K1: 1.2, B: 0.75, Boosts: DefaultBoosts}
+	if docs > 0 {
+		s.AvgLen = float64(total) / float64(docs)
+	}
+	return s
+}
+
+//On the same totals, taking doc lengths from ix
+func (s *Scorer) On(ix *Index) *Scorer {
+	c := *s
+	c.ix = ix
+	return &c
+}
+
+//IDF of a term found in df docs
+func (s *Scorer) IDF(df int) float64 {
+	n := s.N
+	//docs only known from anchors arent counted in N
+	if float64(df) > n {
+		n = float64(df)
+	}
+	return math.Log(1 + (n-float64(df)+0.5)/(float64(df)+0.5))
+}
+
+//saturate a weighted term frequency against the doc's length
+func (s *Scorer) saturate(tf float64, doc uint64) float64 {
+	if tf <= 0 {
+		return 0
+	}
+	norm := 1.0
+	if s.AvgLen > 0 && s.ix != nil {
+		dl := float64(s.ix.Length(doc))
+		//linked to but never fetched, treat as average
+		if dl == 0 {
+			dl = s.AvgLen
+		}
+		norm = 1 - s.B + s.B*dl/s.AvgLen
+	}
+	return tf * (s.K1 + 1) / (tf + s.K1*norm)
+}
+
+//Term score of a posting from a list df long
+func (s *Scorer) Term(p Posting, df int) float64 {
+	tf := 0.0
+	for i := 0; i < NumFields; i++ {
+		tf += s.Boosts[i] * float64(p.Freqs[i])
+	}
+	return s.IDF(df) * s.saturate(tf, p.Doc)
+}
+
+//Score of a doc matching tf times, for clauses like phrases that match df docs
+func (s *Scorer) Score(doc uint64, tf float64, df int) float64 {
+	return s.IDF(df) * s.saturate(tf, doc)
+}
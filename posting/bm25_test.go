@@ -0,0 +1,60 @@
+package posting
+
+import (
+	"testing"
+)
+
+func TestCorpusScorer(t *testing.T) {
+	a, b := newIndex(), newIndex()
+	index(a, "http://a/1", []string{"x", "y"}, nil)
+	index(b, "http://b/1", []string{"x", "y", "z", "w"}, nil)
+	index(b, "http://b/2", []string{"x", "y", "z"}, nil)
+
+	s := CorpusScorer(a, b)
+	if s.N != 3 || s.AvgLen != 3 {
+		t.Errorf("N = %v, AvgLen = %v, want 3 and 3", s.N, s.AvgLen)
+	}
+
+	//the same hits score higher on the shorter doc once the totals are shared
+	short, _ := a.DocID("http://a/1", false)
+	long, _ := b.DocID("http://b/1", false)
+	if sa, sb := s.On(a).Term(posting(short, Body, 0), 2), s.On(b).Term(posting(long, Body, 0), 2); sa <= sb {
+		t.Errorf("short doc scored %v, long doc %v", sa, sb)
+	}
+	if a.Scorer().N != 1 {
+		t.Errorf("single index N = %v, want 1", a.Scorer().N)
+	}
+}
+
+func TestScorer(t *testing.T) {
+	ix := newIndex()
+	index(ix, "http://a", []string{"x"}, nil)
+	index(ix, "http://b", []string{"y"}, nil)
+	s := ix.Scorer()
+
+	if s.IDF(1) <= s.IDF(2) {
+		t.Errorf("rarer terms should weigh more: IDF(1) = %v, IDF(2) = %v", s.IDF(1), s.IDF(2))
+	}
+
+	doc, _ := ix.DocID("http://a", false)
+	tests := []struct {
+		field Field
+		hits  int
+	}{
+		{Title, 1}, {Heading, 1}, {Meta, 1}, {Anchor, 1}, {Body, 1}, {Body, 5},
+	}
+	scores := map[Field]float64{}
+	for _, tt := range tests {
+		p := Posting{Doc: doc, Fields: tt.field, Freq: uint32(tt.hits)}
+		p.Freqs[fieldIndex(tt.field)] = uint32(tt.hits)
+		score := s.Term(p, 1)
+		if tt.hits == 1 {
+			scores[tt.field] = score
+		} else if score <= scores[Body] {
+			t.Errorf("more hits should score higher: %v vs %v", score, scores[Body])
+		}
+	}
+	if !(scores[Title] > scores[Heading] && scores[Heading] > scores[Meta] && scores[Meta] > scores[Body]) {
+		t.Errorf("field boosts out of order: %v", scores)
+	}
+}
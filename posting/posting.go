@@ -0,0 +1,1040 @@
+package posting
+
+/*
+	Keyword index postings.
+	Urls are given integer document ids in the docs collection, and each
+	keyword in keyword-index maps to a binary posting list of
+		uvarint(doc id delta) uvarint(fields) uvarint(frequency) * fields set
+		uvarint(position count) uvarint(position delta) * position count
+	entries sorted by doc id. fields is a bitmask of which parts of the page
+	the keyword was found in, with a frequency for each, positions are word
+	offsets within the doc.
+	doc-terms keeps the keywords of each doc so a doc can be pulled back out
+	of the index without scanning every keyword, doc-lengths and index-stats
+	hold the word counts needed for scoring. index-stats also records that
+	the index is in this format, lists from before it are never overwritten
+	and have to go through the crawler's migrate-index.
+	host-docs is keyed by the doc's host with its labels reversed, then the
+	doc id (com.example.www/<id>), so a host and its subdomains are one
+	range of keys.
+	Link text is indexed as the Anchor field of the page linked to. What
+	each linking page contributed is kept in anchor-text, keyed by target
+	then source doc id, so reindexing either page replaces just that
+	contribution. Each source gets its own slot of positions past
+	AnchorBase so phrases dont run from one linking page into the next.
+*/
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/steveyen/gkvlite"
+)
+
+//Field of a page a keyword was found in
+type Field uint8
+
+const (
+	Title Field = 1 << iota
+	Heading
+	Meta
+	Body
+	Anchor //text of links pointing at the page
+)
+
+//NumFields is how many Field bits there are
+const NumFields = 5
+
+//index of a single field bit into Posting.Freqs
+func fieldIndex(f Field) int {
+	return bits.TrailingZeros8(uint8(f))
+}
+
+//Posting for one doc
+type Posting struct {
+	Doc       uint64
+	Freq      uint32 //total over all fields
+	Fields    Field
+	Freqs     [NumFields]uint32 //per field, indexed by field bit
+	Positions []uint32
+}
+
+//FieldFreq is how many times the keyword was found in field
+func (p Posting) FieldFreq(f Field) uint32 {
+	return p.Freqs[fieldIndex(f)]
+}
+
+//Only the hits of p in field f
+func (p Posting) Only(f Field) Posting {
+	only := Posting{Doc: p.Doc, Fields: p.Fields & f, Positions: p.Positions}
+	for i := 0; i < NumFields; i++ {
+		if f&(1<<uint(i)) != 0 {
+			only.Freqs[i] = p.Freqs[i]
+			only.Freq += p.Freqs[i]
+		}
+	}
+	return only
+}
+
+//positions left between separately added texts (title, meta, body...) so phrases dont run across them
+const FieldGap = 16
+
+//anchor text added to a doc by other pages starts here, clear of the doc's own words
+const AnchorBase = 1 << 24
+
+//AnchorSlot is the positions each linking page gets for its anchor text, words past it are dropped
+const AnchorSlot = 1024
+
+//how many linking pages can have a slot in one doc
+const maxAnchorSlots = (1<<32 - AnchorBase) / AnchorSlot
+
+//ErrCorrupt is returned for a posting list that doesnt decode, like one from before the binary format
+var ErrCorrupt = errors.New("corrupt posting list")
+
+//version of the keyword-index format, kept in index-stats once an index is known to be in it
+const formatVersion = 2
+
+//Decode a keyword's posting list
+func Decode(list []byte) ([]Posting, error) {
+	postings := []Posting{}
+	doc := uint64(0)
+	for len(list) > 0 {
+		delta, n := binary.Uvarint(list)
+		if n <= 0 {
+			return postings, ErrCorrupt
+		}
+		list = list[n:]
+		fields, n := binary.Uvarint(list)
+		if n <= 0 {
+			return postings, ErrCorrupt
+		}
+		list = list[n:]
+
+		p := Posting{Fields: Field(fields)}
+		for i := 0; i < NumFields; i++ {
+			if fields&(1<<uint(i)) == 0 {
+				continue
+			}
+			freq, n := binary.Uvarint(list)
+			if n <= 0 {
+				return postings, ErrCorrupt
+			}
+			list = list[n:]
+			p.Freqs[i] = uint32(freq)
+			p.Freq += uint32(freq)
+		}
+
+		count, n := binary.Uvarint(list)
+		if n <= 0 {
+			return postings, ErrCorrupt
+		}
+		list = list[n:]
+
+		positions := make([]uint32, 0, count)
+		pos := uint64(0)
+		for i := uint64(0); i < count; i++ {
+			d, n := binary.Uvarint(list)
+			if n <= 0 {
+				return postings, ErrCorrupt
+			}
+			list = list[n:]
+			pos += d
+			positions = append(positions, uint32(pos))
+		}
+
+		doc += delta
+		p.Doc = doc
+		p.Positions = positions
+		postings = append(postings, p)
+	}
+	return postings, nil
+}
+
+//Encode postings, which must be sorted by doc
+func Encode(postings []Posting) []byte {
+	buf := make([]byte, 0, len(postings)*4)
+	tmp := make([]byte, binary.MaxVarintLen64)
+	last := uint64(0)
+	for _, p := range postings {
+		n := binary.PutUvarint(tmp, p.Doc-last)
+		buf = append(buf, tmp[:n]...)
+		n = binary.PutUvarint(tmp, uint64(p.Fields))
+		buf = append(buf, tmp[:n]...)
+		for i := 0; i < NumFields; i++ {
+			if p.Fields&(1<<uint(i)) != 0 {
+				n = binary.PutUvarint(tmp, uint64(p.Freqs[i]))
+				buf = append(buf, tmp[:n]...)
+			}
+		}
+		n = binary.PutUvarint(tmp, uint64(len(p.Positions)))
+		buf = append(buf, tmp[:n]...)
+		lastpos := uint32(0)
+		for _, pos := range p.Positions {
+			n = binary.PutUvarint(tmp, uint64(pos-lastpos))
+			buf = append(buf, tmp[:n]...)
+			lastpos = pos
+		}
+		last = p.Doc
+	}
+	return buf
+}
+
+//Merge adds p to the list, combining positions and fields if the doc is already there
+func Merge(list []byte, p Posting) ([]byte, error) {
+	postings, err := Decode(list)
+	if err != nil {
+		return list, err
+	}
+	i := sort.Search(len(postings), func(i int) bool { return postings[i].Doc >= p.Doc })
+	if i < len(postings) && postings[i].Doc == p.Doc {
+		postings[i].Positions = mergePositions(postings[i].Positions, p.Positions)
+		postings[i].Fields |= p.Fields
+		for f := 0; f < NumFields; f++ {
+			postings[i].Freqs[f] += p.Freqs[f]
+		}
+		postings[i].Freq += p.Freq
+		return Encode(postings), nil
+	}
+
+	postings = append(postings, Posting{})
+	copy(postings[i+1:], postings[i:])
+	postings[i] = p
+	return Encode(postings), nil
+}
+
+func mergePositions(a []uint32, b []uint32) []uint32 {
+	merged := make([]uint32, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		if j >= len(b) || (i < len(a) && a[i] < b[j]) {
+			merged = append(merged, a[i])
+			i++
+		} else if i >= len(a) || b[j] < a[i] {
+			merged = append(merged, b[j])
+			j++
+		} else {
+			merged = append(merged, a[i])
+			i++
+			j++
+		}
+	}
+	return merged
+}
+
+//Phrase finds docs where the words of a phrase appear in order. lists holds the
+//postings of each indexed word and offsets their position within the phrase.
+//Returns doc -> number of times the phrase occurs.
+func Phrase(lists [][]Posting, offsets []int) map[uint64]int {
+	found := map[uint64]int{}
+	if len(lists) == 0 {
+		return found
+	}
+
+	bydoc := make([]map[uint64][]uint32, len(lists))
+	for i, list := range lists {
+		bydoc[i] = map[uint64][]uint32{}
+		for _, p := range list {
+			bydoc[i][p.Doc] = p.Positions
+		}
+	}
+
+	for _, p := range lists[0] {
+		for _, start := range p.Positions {
+			base := int(start) - offsets[0]
+			if base < 0 {
+				continue
+			}
+			match := true
+			for i := 1; i < len(lists); i++ {
+				if !hasPosition(bydoc[i][p.Doc], uint32(base+offsets[i])) {
+					match = false
+					break
+				}
+			}
+			if match {
+				found[p.Doc]++
+			}
+		}
+	}
+	return found
+}
+
+func hasPosition(positions []uint32, pos uint32) bool {
+	i := sort.Search(len(positions), func(i int) bool { return positions[i] >= pos })
+	return i < len(positions) && positions[i] == pos
+}
+
+//Near finds docs where a and b occur within distance words of each other.
+//Returns doc -> the closest distance found.
+func Near(a []Posting, b []Posting, distance int) map[uint64]int {
+	found := map[uint64]int{}
+	bpos := map[uint64][]uint32{}
+	for _, p := range b {
+		bpos[p.Doc] = p.Positions
+	}
+
+	for _, p := range a {
+		other, ok := bpos[p.Doc]
+		if !ok {
+			continue
+		}
+		best := -1
+		i, j := 0, 0
+		for i < len(p.Positions) && j < len(other) {
+			d := int(p.Positions[i]) - int(other[j])
+			if d < 0 {
+				d = -d
+				i++
+			} else {
+				j++
+			}
+			if d > 0 && (best < 0 || d < best) {
+				best = d
+			}
+		}
+		if best > 0 && best <= distance {
+			found[p.Doc] = best
+		}
+	}
+	return found
+}
+
+//Subtract the hits of p from its doc in the list, dropping the doc once nothing is left
+func Subtract(list []byte, p Posting) ([]byte, error) {
+	postings, err := Decode(list)
+	if err != nil {
+		return list, err
+	}
+	i := sort.Search(len(postings), func(i int) bool { return postings[i].Doc >= p.Doc })
+	if i >= len(postings) || postings[i].Doc != p.Doc {
+		return list, nil
+	}
+
+	q := &postings[i]
+	q.Fields = 0
+	q.Freq = 0
+	for f := 0; f < NumFields; f++ {
+		if q.Freqs[f] > p.Freqs[f] {
+			q.Freqs[f] -= p.Freqs[f]
+		} else {
+			q.Freqs[f] = 0
+		}
+		if q.Freqs[f] > 0 {
+			q.Fields |= 1 << uint(f)
+			q.Freq += q.Freqs[f]
+		}
+	}
+	kept := make([]uint32, 0, len(q.Positions))
+	for _, pos := range q.Positions {
+		if !hasPosition(p.Positions, pos) {
+			kept = append(kept, pos)
+		}
+	}
+	q.Positions = kept
+
+	if q.Freq == 0 {
+		postings = append(postings[:i], postings[i+1:]...)
+	}
+	return Encode(postings), nil
+}
+
+//Remove doc from the list. Returns the new list and false if it wasnt there.
+func Remove(list []byte, doc uint64) ([]byte, bool, error) {
+	postings, err := Decode(list)
+	if err != nil {
+		return list, false, err
+	}
+	i := sort.Search(len(postings), func(i int) bool { return postings[i].Doc >= doc })
+	if i >= len(postings) || postings[i].Doc != doc {
+		return list, false, nil
+	}
+	postings = append(postings[:i], postings[i+1:]...)
+	return Encode(postings), true, nil
+}
+
+//keywordError says which keyword's list couldnt be changed
+func keywordError(keyword string, err error) error {
+	return fmt.Errorf("keyword %q: %w", keyword, err)
+}
+
+//doc ids are stored big endian so the docs collection sorts by id
+func docKey(doc uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, doc)
+	return k
+}
+
+//Index over the keyword-index, docs, doc-ids and doc-terms collections of a store
+type Index struct {
+	Keywords *gkvlite.Collection //keyword -> posting list
+	Docs     *gkvlite.Collection //doc id -> url
+	DocIDs   *gkvlite.Collection //url -> doc id
+	DocTerms *gkvlite.Collection //doc id -> keywords, newline separated
+	Lengths  *gkvlite.Collection //doc id -> uvarint words indexed from the doc itself
+	Stats    *gkvlite.Collection //"docs" and "length" -> uvarint totals over docs with a length
+	Hosts    *gkvlite.Collection //reversed host/doc id -> nothing, for docs that have been indexed
+	Anchors  *gkvlite.Collection //target doc id + source doc id -> slot and the anchor postings source gave target
+	Sources  *gkvlite.Collection //source doc id + target doc id -> nothing
+
+	mu   sync.Mutex
+	next uint64
+}
+
+//Open the index collections of a store, creating them if needed
+func Open(store *gkvlite.Store) *Index {
+	ix := &Index{
+		Keywords: store.SetCollection("keyword-index", nil),
+		Docs:     store.SetCollection("docs", nil),
+		DocIDs:   store.SetCollection("doc-ids", nil),
+		DocTerms: store.SetCollection("doc-terms", nil),
+		Lengths:  store.SetCollection("doc-lengths", nil),
+		Stats:    store.SetCollection("index-stats", nil),
+		Hosts:    store.SetCollection("host-docs", nil),
+		Anchors:  store.SetCollection("anchor-text", nil),
+		Sources:  store.SetCollection("anchor-sources", nil),
+		next:     1,
+	}
+	last, err := ix.Docs.MaxItem(false)
+	if err == nil && last != nil && len(last.Key) == 8 {
+		ix.next = binary.BigEndian.Uint64(last.Key) + 1
+	}
+	//a new index starts out in the current format
+	if first, err := ix.Keywords.MinItem(false); err == nil && first == nil {
+		ix.SetCurrent()
+	}
+	return ix
+}
+
+//Current reports whether the keyword index is known to be in the binary format.
+//Indexes from before it hold lists Write wont change until they are migrated.
+func (ix *Index) Current() bool {
+	return ix.getUvarint(ix.Stats, []byte("version")) >= formatVersion
+}
+
+//SetCurrent marks the keyword index as being in the binary format, once any old lists are migrated
+func (ix *Index) SetCurrent() {
+	ix.setUvarint(ix.Stats, []byte("version"), formatVersion)
+}
+
+//DocID of a url, assigning a new one if create is set. Returns false if there isnt one.
+func (ix *Index) DocID(theurl string, create bool) (uint64, bool) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	val, err := ix.DocIDs.Get([]byte(theurl))
+	if err == nil && len(val) == 8 {
+		return binary.BigEndian.Uint64(val), true
+	}
+	if !create {
+		return 0, false
+	}
+
+	doc := ix.next
+	ix.next++
+	ix.Docs.Set(docKey(doc), []byte(theurl))
+	ix.DocIDs.Set([]byte(theurl), docKey(doc))
+	return doc, true
+}
+
+//ReverseHost turns www.example.com into com.example.www
+func ReverseHost(host string) string {
+	labels := strings.Split(strings.Trim(strings.ToLower(host), "."), ".")
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return strings.Join(labels, ".")
+}
+
+func hostKey(theurl string, doc uint64) []byte {
+	u, err := url.Parse(theurl)
+	if err != nil || u.Hostname() == "" {
+		return nil
+	}
+	return append([]byte(ReverseHost(u.Hostname())+"/"), docKey(doc)...)
+}
+
+//Site docs on host or any of its subdomains
+func (ix *Index) Site(host string) map[uint64]bool {
+	docs := map[uint64]bool{}
+	prefix := ReverseHost(host)
+	ix.Hosts.VisitItemsAscend([]byte(prefix), false, func(i *gkvlite.Item) bool {
+		key := string(i.Key)
+		if !strings.HasPrefix(key, prefix) {
+			return false
+		}
+		//com.example/ and com.example.www/ but not com.examples/
+		rest := key[len(prefix):]
+		if len(rest) >= 9 && (rest[0] == '/' || rest[0] == '.') && rest[len(rest)-9] == '/' {
+			docs[binary.BigEndian.Uint64(i.Key[len(i.Key)-8:])] = true
+		}
+		return true
+	})
+	return docs
+}
+
+//IndexHosts rebuilds host-docs from the docs that have been indexed, dropping
+//any left by pages only ever linked to. Returns how many docs it covers.
+func (ix *Index) IndexHosts() int {
+	//collect first, dont modify while visiting
+	stale := [][]byte{}
+	ix.Hosts.VisitItemsAscend([]byte{}, true, func(i *gkvlite.Item) bool {
+		stale = append(stale, i.Key)
+		return true
+	})
+	for _, key := range stale {
+		ix.Hosts.Delete(key)
+	}
+
+	keys := [][]byte{}
+	ix.EachDoc(func(doc uint64, theurl string) bool {
+		if ix.Length(doc) == 0 {
+			return true
+		}
+		if key := hostKey(theurl, doc); key != nil {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	for _, key := range keys {
+		ix.Hosts.Set(key, []byte{})
+	}
+	return len(keys)
+}
+
+//URL of a doc id, empty if unknown
+func (ix *Index) URL(doc uint64) string {
+	val, err := ix.Docs.Get(docKey(doc))
+	if err != nil || val == nil {
+		return ""
+	}
+	return string(val)
+}
+
+//EachDoc calls fn with every doc id and url until it returns false
+func (ix *Index) EachDoc(fn func(doc uint64, url string) bool) {
+	ix.Docs.VisitItemsAscend([]byte{}, true, func(i *gkvlite.Item) bool {
+		if len(i.Key) != 8 {
+			return true
+		}
+		return fn(binary.BigEndian.Uint64(i.Key), string(i.Val))
+	})
+}
+
+//Postings for a keyword
+func (ix *Index) Postings(keyword string) []Posting {
+	list, err := ix.Keywords.Get([]byte(keyword))
+	if err != nil || list == nil {
+		return nil
+	}
+	postings, _ := Decode(list)
+	return postings
+}
+
+func (ix *Index) getUvarint(coll *gkvlite.Collection, key []byte) uint64 {
+	val, err := coll.Get(key)
+	if err != nil || len(val) == 0 {
+		return 0
+	}
+	v, n := binary.Uvarint(val)
+	if n <= 0 {
+		return 0
+	}
+	return v
+}
+
+func (ix *Index) setUvarint(coll *gkvlite.Collection, key []byte, v uint64) {
+	if v == 0 {
+		coll.Delete(key)
+		return
+	}
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, v)
+	coll.Set(key, buf[:n])
+}
+
+//Length of a doc in indexed words, 0 if it has only been linked to
+func (ix *Index) Length(doc uint64) uint64 {
+	return ix.getUvarint(ix.Lengths, docKey(doc))
+}
+
+//Totals of docs with a length and their combined length
+func (ix *Index) Totals() (uint64, uint64) {
+	return ix.getUvarint(ix.Stats, []byte("docs")), ix.getUvarint(ix.Stats, []byte("length"))
+}
+
+//move the totals when a doc's length changes
+func (ix *Index) setLength(doc uint64, length uint64) {
+	old := ix.Length(doc)
+	if old == length {
+		return
+	}
+	docs, total := ix.Totals()
+	if old > 0 {
+		docs--
+		total -= old
+	}
+	if length > 0 {
+		docs++
+		total += length
+	}
+	ix.setUvarint(ix.Stats, []byte("docs"), docs)
+	ix.setUvarint(ix.Stats, []byte("length"), total)
+	ix.setUvarint(ix.Lengths, docKey(doc), length)
+}
+
+func (ix *Index) terms(doc uint64) []string {
+	val, err := ix.DocTerms.Get(docKey(doc))
+	if err != nil || len(val) == 0 {
+		return nil
+	}
+	return strings.Split(string(val), "\n")
+}
+
+//drop a doc from every keyword it is listed under. Lists that dont decode
+//are left as they are, and the first of them returned.
+func (ix *Index) removeDoc(doc uint64) error {
+	var first error
+	for _, term := range ix.terms(doc) {
+		list, err := ix.Keywords.Get([]byte(term))
+		if err != nil || list == nil {
+			continue
+		}
+		list, ok, err := Remove(list, doc)
+		if err != nil {
+			if first == nil {
+				first = keywordError(term, err)
+			}
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if len(list) == 0 {
+			ix.Keywords.Delete([]byte(term))
+		} else {
+			ix.Keywords.Set([]byte(term), list)
+		}
+	}
+	ix.DocTerms.Delete(docKey(doc))
+	ix.setLength(doc, 0)
+	if key := hostKey(ix.URL(doc), doc); key != nil {
+		ix.Hosts.Delete(key)
+	}
+	return first
+}
+
+//Phrase finds docs containing the words in order, "" being words that arent indexed.
+//Returns doc -> number of occurrences.
+func (ix *Index) Phrase(words []string) map[uint64]int {
+	lists := [][]Posting{}
+	offsets := []int{}
+	for i, w := range words {
+		if w == "" {
+			continue
+		}
+		lists = append(lists, ix.Postings(w))
+		offsets = append(offsets, i)
+	}
+	return Phrase(lists, offsets)
+}
+
+//Near finds docs with left and right within distance words of each other.
+//Returns doc -> closest distance.
+func (ix *Index) Near(left string, right string, distance int) map[uint64]int {
+	return Near(ix.Postings(left), ix.Postings(right), distance)
+}
+
+//Remove a url from the index. Its doc id is kept in case it comes back, along
+//with the anchor text other pages gave it. Anchor text it gave other pages goes.
+//Keywords whose lists dont decode are left alone and the first is returned.
+func (ix *Index) Remove(url string) error {
+	doc, ok := ix.DocID(url, false)
+	if !ok {
+		return nil
+	}
+	err := ix.removeDoc(doc)
+	c := newChanges()
+	ix.replaceAnchors(doc, nil, nil, c)
+	if applyErr := c.apply(ix); err == nil {
+		err = applyErr
+	}
+	return err
+}
+
+//target and source doc ids as one key
+func pairKey(a uint64, b uint64) []byte {
+	return append(docKey(a), docKey(b)...)
+}
+
+//anchor-text values: uvarint slot, then per keyword uvarint length, keyword, uvarint length, encoded posting
+func encodeAnchors(slot uint32, terms map[string]Posting) []byte {
+	keys := make([]string, 0, len(terms))
+	for k := range terms {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	tmp := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(tmp, uint64(slot))
+	buf := append([]byte{}, tmp[:n]...)
+	for _, k := range keys {
+		enc := Encode([]Posting{terms[k]})
+		n = binary.PutUvarint(tmp, uint64(len(k)))
+		buf = append(buf, tmp[:n]...)
+		buf = append(buf, k...)
+		n = binary.PutUvarint(tmp, uint64(len(enc)))
+		buf = append(buf, tmp[:n]...)
+		buf = append(buf, enc...)
+	}
+	return buf
+}
+
+func decodeAnchors(val []byte) (uint32, map[string]Posting, error) {
+	terms := map[string]Posting{}
+	slot, n := binary.Uvarint(val)
+	if n <= 0 {
+		return 0, terms, ErrCorrupt
+	}
+	val = val[n:]
+	for len(val) > 0 {
+		l, n := binary.Uvarint(val)
+		if n <= 0 || uint64(len(val)-n) < l {
+			return uint32(slot), terms, ErrCorrupt
+		}
+		k := string(val[n : n+int(l)])
+		val = val[n+int(l):]
+		l, n = binary.Uvarint(val)
+		if n <= 0 || uint64(len(val)-n) < l {
+			return uint32(slot), terms, ErrCorrupt
+		}
+		postings, err := Decode(val[n : n+int(l)])
+		val = val[n+int(l):]
+		if err != nil || len(postings) != 1 {
+			return uint32(slot), terms, ErrCorrupt
+		}
+		terms[k] = postings[0]
+	}
+	return uint32(slot), terms, nil
+}
+
+//eachAnchor calls fn with every source that gave target anchor text
+func (ix *Index) eachAnchor(target uint64, fn func(source uint64, slot uint32, terms map[string]Posting)) {
+	prefix := docKey(target)
+	ix.Anchors.VisitItemsAscend(prefix, true, func(i *gkvlite.Item) bool {
+		if len(i.Key) != 16 || string(i.Key[:8]) != string(prefix) {
+			return false
+		}
+		slot, terms, err := decodeAnchors(i.Val)
+		if err == nil {
+			fn(binary.BigEndian.Uint64(i.Key[8:]), slot, terms)
+		}
+		return true
+	})
+}
+
+//docs source gave anchor text to
+func (ix *Index) anchorTargets(source uint64) []uint64 {
+	targets := []uint64{}
+	prefix := docKey(source)
+	ix.Sources.VisitItemsAscend(prefix, false, func(i *gkvlite.Item) bool {
+		if len(i.Key) != 16 || string(i.Key[:8]) != string(prefix) {
+			return false
+		}
+		targets = append(targets, binary.BigEndian.Uint64(i.Key[8:]))
+		return true
+	})
+	return targets
+}
+
+//lowest slot of target no source is using
+func (ix *Index) freeSlot(target uint64) (uint32, bool) {
+	used := map[uint32]bool{}
+	ix.eachAnchor(target, func(source uint64, slot uint32, terms map[string]Posting) {
+		used[slot] = true
+	})
+	for slot := uint32(0); slot < maxAnchorSlots; slot++ {
+		if !used[slot] {
+			return slot, true
+		}
+	}
+	return 0, false
+}
+
+//replace the anchor text source gave each doc with next, which has positions from AnchorBase.
+//docs in skip are being reindexed and pick up the stored anchor text themselves.
+func (ix *Index) replaceAnchors(source uint64, next map[uint64]map[string]*Posting, skip map[uint64]bool, c *changes) {
+	targets := map[uint64]bool{}
+	for _, t := range ix.anchorTargets(source) {
+		targets[t] = true
+	}
+	for t := range next {
+		targets[t] = true
+	}
+
+	for target := range targets {
+		key := pairKey(target, source)
+		val, err := ix.Anchors.Get(key)
+		had := err == nil && val != nil
+		slot := uint32(0)
+		if had {
+			var old map[string]Posting
+			slot, old, _ = decodeAnchors(val)
+			if !skip[target] {
+				for k, p := range old {
+					c.sub(k, p)
+				}
+			}
+		}
+
+		terms := next[target]
+		if len(terms) == 0 {
+			if had {
+				ix.Anchors.Delete(key)
+				ix.Sources.Delete(pairKey(source, target))
+			}
+			continue
+		}
+		if !had {
+			var ok bool
+			if slot, ok = ix.freeSlot(target); !ok {
+				continue
+			}
+		}
+
+		shift := slot * AnchorSlot
+		shifted := map[string]Posting{}
+		for k, p := range terms {
+			q := *p
+			q.Positions = make([]uint32, len(p.Positions))
+			for i, pos := range p.Positions {
+				q.Positions[i] = pos + shift
+			}
+			shifted[k] = q
+			if !skip[target] {
+				c.add(k, q)
+			}
+		}
+		ix.Anchors.Set(key, encodeAnchors(slot, shifted))
+		ix.Sources.Set(pairKey(source, target), []byte{})
+	}
+}
+
+//changes to keyword lists, applied so each list is rewritten once
+type changes struct {
+	adds  map[string][]Posting
+	subs  map[string][]Posting
+	terms map[uint64]map[string]bool //keywords added per doc, for doc-terms
+}
+
+func newChanges() *changes {
+	return &changes{
+		adds:  map[string][]Posting{},
+		subs:  map[string][]Posting{},
+		terms: map[uint64]map[string]bool{},
+	}
+}
+
+func (c *changes) add(keyword string, p Posting) {
+	c.adds[keyword] = append(c.adds[keyword], p)
+	if c.terms[p.Doc] == nil {
+		c.terms[p.Doc] = map[string]bool{}
+	}
+	c.terms[p.Doc][keyword] = true
+}
+
+func (c *changes) sub(keyword string, p Posting) {
+	c.subs[keyword] = append(c.subs[keyword], p)
+}
+
+//apply the changes. A keyword whose list doesnt decode is left as it is rather
+//than overwritten, the first of them is returned once the rest are done.
+func (c *changes) apply(ix *Index) error {
+	var first error
+	keywords := map[string]bool{}
+	for k := range c.adds {
+		keywords[k] = true
+	}
+	for k := range c.subs {
+		keywords[k] = true
+	}
+	for k := range keywords {
+		list, _ := ix.Keywords.Get([]byte(k))
+		var err error
+		for _, p := range c.subs[k] {
+			if list, err = Subtract(list, p); err != nil {
+				break
+			}
+		}
+		for _, p := range c.adds[k] {
+			if err != nil {
+				break
+			}
+			list, err = Merge(list, p)
+		}
+		if err != nil {
+			if first == nil {
+				first = keywordError(k, err)
+			}
+			continue
+		}
+		if len(list) == 0 {
+			ix.Keywords.Delete([]byte(k))
+		} else {
+			ix.Keywords.Set([]byte(k), list)
+		}
+	}
+
+	//keep doc-terms up to date for later removal
+	for doc, terms := range c.terms {
+		all := map[string]bool{}
+		for _, t := range ix.terms(doc) {
+			all[t] = true
+		}
+		for t := range terms {
+			all[t] = true
+		}
+		list := make([]string, 0, len(all))
+		for t := range all {
+			list = append(list, t)
+		}
+		sort.Strings(list)
+		ix.DocTerms.Set(docKey(doc), []byte(strings.Join(list, "\n")))
+	}
+	return first
+}
+
+//Batch of postings gathered while indexing a page, so each keyword is only
+//read and rewritten once per page rather than once per hit. Hits for docs
+//the batch doesnt Replace are anchor text from the first doc it does.
+type Batch struct {
+	docs      map[uint64]map[string]*Posting
+	replace   map[uint64]bool
+	pos       map[uint64]uint32
+	count     map[uint64]uint64
+	source    uint64
+	hasSource bool
+}
+
+//NewBatch that is empty
+func NewBatch() *Batch {
+	return &Batch{
+		docs:    map[uint64]map[string]*Posting{},
+		replace: map[uint64]bool{},
+		pos:     map[uint64]uint32{},
+		count:   map[uint64]uint64{},
+	}
+}
+
+//next position in doc. docs this batch isnt replacing only get anchor text, kept clear of their own words
+func (b *Batch) position(doc uint64) uint32 {
+	pos, ok := b.pos[doc]
+	if !ok && !b.replace[doc] {
+		pos = AnchorBase
+		b.pos[doc] = pos
+	}
+	return pos
+}
+
+//Skip n word positions in doc, for words that arent indexed or gaps between texts
+func (b *Batch) Skip(doc uint64, n int) {
+	b.pos[doc] = b.position(doc) + uint32(n)
+}
+
+//Replace marks doc as fully reindexed by this batch, its old postings are dropped on Write
+func (b *Batch) Replace(doc uint64) {
+	b.replace[doc] = true
+	if !b.hasSource {
+		b.source = doc
+		b.hasSource = true
+	}
+}
+
+//Add a hit for keyword in doc's field at the next word position
+func (b *Batch) Add(doc uint64, keyword string, field Field) {
+	pos := b.position(doc)
+	if !b.replace[doc] && pos >= AnchorBase+AnchorSlot {
+		return
+	}
+
+	terms, ok := b.docs[doc]
+	if !ok {
+		terms = map[string]*Posting{}
+		b.docs[doc] = terms
+	}
+	p, ok := terms[keyword]
+	if !ok {
+		p = &Posting{Doc: doc}
+		terms[keyword] = p
+	}
+	for i := 0; i < NumFields; i++ {
+		if field&(1<<uint(i)) != 0 {
+			p.Freqs[i]++
+		}
+	}
+	p.Freq++
+	p.Fields |= field
+
+	p.Positions = append(p.Positions, pos)
+	b.pos[doc] = pos + 1
+	b.count[doc]++
+}
+
+//Len is the number of distinct keywords in the batch
+func (b *Batch) Len() int {
+	seen := map[string]bool{}
+	for _, terms := range b.docs {
+		for k := range terms {
+			seen[k] = true
+		}
+	}
+	return len(seen)
+}
+
+//Write the batch into the index. Keywords whose lists dont decode are left
+//alone and the first is returned, see Current.
+func (ix *Index) Write(b *Batch) error {
+	var first error
+	c := newChanges()
+
+	//anchor text replaces whatever the same page gave each doc last time
+	anchors := map[uint64]map[string]*Posting{}
+	for doc, terms := range b.docs {
+		if !b.replace[doc] {
+			anchors[doc] = terms
+		}
+	}
+	if b.hasSource || len(anchors) > 0 {
+		ix.replaceAnchors(b.source, anchors, b.replace, c)
+	}
+
+	for doc := range b.replace {
+		if err := ix.removeDoc(doc); err != nil && first == nil {
+			first = err
+		}
+		ix.setLength(doc, b.count[doc])
+		if key := hostKey(ix.URL(doc), doc); key != nil {
+			ix.Hosts.Set(key, []byte{})
+		}
+
+		//anchor text from other pages outlives the doc's own reindex
+		ix.eachAnchor(doc, func(source uint64, slot uint32, terms map[string]Posting) {
+			for k, p := range terms {
+				c.add(k, p)
+			}
+		})
+		for k, p := range b.docs[doc] {
+			c.add(k, *p)
+		}
+	}
+
+	if err := c.apply(ix); err != nil && first == nil {
+		first = err
+	}
+	return first
+}
@@ -0,0 +1,327 @@
+package posting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/steveyen/gkvlite"
+)
+
+func posting(doc uint64, field Field, positions ...uint32) Posting {
+	p := Posting{Doc: doc, Fields: field, Positions: positions}
+	p.Freqs[fieldIndex(field)] = uint32(len(positions))
+	p.Freq = uint32(len(positions))
+	return p
+}
+
+func TestEncodeDecode(t *testing.T) {
+	big := posting(1<<40, Body, 0, 1, 1<<20, AnchorBase+5)
+	both := posting(7, Title, 0, 3)
+	both.Fields |= Anchor
+	both.Freqs[fieldIndex(Anchor)] = 2
+	both.Freq = 4
+
+	tests := []struct {
+		name     string
+		postings []Posting
+	}{
+		{"empty", []Posting{}},
+		{"one", []Posting{posting(1, Body, 4)}},
+		{"no positions", []Posting{{Doc: 3, Fields: Meta, Freq: 1, Freqs: [NumFields]uint32{0, 0, 1}, Positions: []uint32{}}}},
+		{"deltas", []Posting{posting(2, Title, 0), posting(5, Heading, 1, 2), posting(300, Body, 7)}},
+		{"several fields", []Posting{both}},
+		{"large", []Posting{posting(1, Body, 0), big}},
+	}
+	for _, tt := range tests {
+		got, err := Decode(Encode(tt.postings))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.postings) {
+			t.Errorf("%s: round trip got %+v, want %+v", tt.name, got, tt.postings)
+		}
+	}
+}
+
+func TestDecodeCorrupt(t *testing.T) {
+	list := Encode([]Posting{posting(1, Body, 0, 1)})
+	tests := []struct {
+		name string
+		list []byte
+	}{
+		{"unterminated varint", []byte{0x80}},
+		{"no fields", list[:1]},
+		{"no frequency", list[:2]},
+		{"no position count", list[:3]},
+		{"missing positions", list[:len(list)-1]},
+	}
+	for _, tt := range tests {
+		if _, err := Decode(tt.list); err != ErrCorrupt {
+			t.Errorf("%s: err %v, want ErrCorrupt", tt.name, err)
+		}
+	}
+}
+
+func TestMergeSubtractRemove(t *testing.T) {
+	list := Encode([]Posting{posting(2, Body, 1), posting(9, Body, 4)})
+
+	//new doc goes in order
+	list, _ = Merge(list, posting(5, Title, 0))
+	//existing doc combines
+	list, _ = Merge(list, posting(2, Anchor, AnchorBase))
+
+	got, _ := Decode(list)
+	docs := []uint64{}
+	for _, p := range got {
+		docs = append(docs, p.Doc)
+	}
+	if !reflect.DeepEqual(docs, []uint64{2, 5, 9}) {
+		t.Fatalf("docs %v, want [2 5 9]", docs)
+	}
+	if got[0].Fields != Body|Anchor || got[0].Freq != 2 || !reflect.DeepEqual(got[0].Positions, []uint32{1, AnchorBase}) {
+		t.Errorf("merged posting %+v", got[0])
+	}
+
+	//subtracting the anchor hit leaves the body hit
+	list, _ = Subtract(list, posting(2, Anchor, AnchorBase))
+	got, _ = Decode(list)
+	if !reflect.DeepEqual(got[0], posting(2, Body, 1)) {
+		t.Errorf("after Subtract got %+v, want %+v", got[0], posting(2, Body, 1))
+	}
+
+	//subtracting everything drops the doc
+	list, _ = Subtract(list, posting(5, Title, 0))
+	got, _ = Decode(list)
+	if len(got) != 2 || got[1].Doc != 9 {
+		t.Errorf("after Subtract of all of doc 5 got %+v", got)
+	}
+
+	list, ok, _ := Remove(list, 9)
+	if !ok {
+		t.Error("Remove(9) = false")
+	}
+	if _, ok, _ := Remove(list, 9); ok {
+		t.Error("second Remove(9) = true")
+	}
+	got, _ = Decode(list)
+	if len(got) != 1 || got[0].Doc != 2 {
+		t.Errorf("after Remove got %+v", got)
+	}
+}
+
+func TestOldFormatNotOverwritten(t *testing.T) {
+	old := []byte("http://a||||http://b\t8||||")
+
+	//the list functions refuse it and hand it back as it was
+	ops := []struct {
+		name string
+		fn   func([]byte) ([]byte, error)
+	}{
+		{"Merge", func(l []byte) ([]byte, error) { return Merge(l, posting(1, Body, 0)) }},
+		{"Subtract", func(l []byte) ([]byte, error) { return Subtract(l, posting(1, Body, 0)) }},
+		{"Remove", func(l []byte) ([]byte, error) { l, _, err := Remove(l, 1); return l, err }},
+	}
+	for _, op := range ops {
+		got, err := op.fn(old)
+		if err != ErrCorrupt || string(got) != string(old) {
+			t.Errorf("%s on an old list = %q, %v, want it unchanged and ErrCorrupt", op.name, got, err)
+		}
+	}
+
+	//so does the index, an old db isnt current until migrated
+	store, _ := gkvlite.NewStore(nil)
+	store.SetCollection("keyword-index", nil).Set([]byte("fish"), old)
+	ix := Open(store)
+	if ix.Current() {
+		t.Error("index with old lists is Current")
+	}
+	doc, _ := ix.DocID("http://c", true)
+	b := NewBatch()
+	b.Replace(doc)
+	b.Add(doc, "fish", Body)
+	b.Add(doc, "cats", Body)
+	if err := ix.Write(b); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Write err = %v, want ErrCorrupt", err)
+	}
+	if got, _ := ix.Keywords.Get([]byte("fish")); string(got) != string(old) {
+		t.Errorf("old list overwritten with %q", got)
+	}
+	if _, ok := find(ix, "cats", "http://c"); !ok {
+		t.Error("keywords that decode werent written")
+	}
+	if err := ix.Remove("http://c"); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Remove err = %v, want ErrCorrupt", err)
+	}
+
+	if !newIndex().Current() {
+		t.Error("new index isnt Current")
+	}
+}
+
+func newIndex() *Index {
+	store, _ := gkvlite.NewStore(nil)
+	return Open(store)
+}
+
+//index a page the way the crawler does: its own words, then anchor text for pages it links to
+func index(ix *Index, theurl string, words []string, links map[string][]string) {
+	doc, _ := ix.DocID(theurl, true)
+	b := NewBatch()
+	b.Replace(doc)
+	for _, w := range words {
+		b.Add(doc, w, Body)
+	}
+	b.Skip(doc, FieldGap)
+	for target, text := range links {
+		t, _ := ix.DocID(target, true)
+		for _, w := range text {
+			b.Add(t, w, Anchor)
+		}
+		b.Skip(t, FieldGap)
+	}
+	ix.Write(b)
+}
+
+func find(ix *Index, keyword string, theurl string) (Posting, bool) {
+	doc, _ := ix.DocID(theurl, false)
+	for _, p := range ix.Postings(keyword) {
+		if p.Doc == doc {
+			return p, true
+		}
+	}
+	return Posting{}, false
+}
+
+func TestReindexKeepsAnchorText(t *testing.T) {
+	ix := newIndex()
+	index(ix, "http://a", []string{"alpha"}, map[string][]string{"http://t": {"great", "page"}})
+	index(ix, "http://t", []string{"target"}, nil)
+
+	//recrawling the target keeps what a said about it
+	index(ix, "http://t", []string{"target", "again"}, nil)
+	p, ok := find(ix, "great", "http://t")
+	if !ok || p.FieldFreq(Anchor) != 1 {
+		t.Errorf("anchor text after target reindex: %+v, %v", p, ok)
+	}
+	if _, ok := find(ix, "target", "http://t"); !ok {
+		t.Error("target lost its own words")
+	}
+	doc, _ := ix.DocID("http://t", false)
+	if ix.Length(doc) != 2 {
+		t.Errorf("Length = %d, want 2, anchor text isnt part of it", ix.Length(doc))
+	}
+}
+
+func TestReindexReplacesAnchorText(t *testing.T) {
+	ix := newIndex()
+	index(ix, "http://t", []string{"target", "great"}, nil)
+	for i := 0; i < 3; i++ {
+		index(ix, "http://a", []string{"alpha"}, map[string][]string{"http://t": {"great", "page"}})
+	}
+	p, _ := find(ix, "great", "http://t")
+	if p.FieldFreq(Anchor) != 1 || p.FieldFreq(Body) != 1 {
+		t.Errorf("after recrawling the source 3 times got %+v, want 1 anchor and 1 body hit", p)
+	}
+
+	//the link text changes
+	index(ix, "http://a", []string{"alpha"}, map[string][]string{"http://t": {"other"}})
+	if p, _ := find(ix, "great", "http://t"); p.FieldFreq(Anchor) != 0 || p.FieldFreq(Body) != 1 {
+		t.Errorf("old link text still counted: %+v", p)
+	}
+	if _, ok := find(ix, "page", "http://t"); ok {
+		t.Error("old link text still indexed")
+	}
+	if _, ok := find(ix, "other", "http://t"); !ok {
+		t.Error("new link text not indexed")
+	}
+
+	//the link goes away
+	index(ix, "http://a", []string{"alpha"}, nil)
+	if _, ok := find(ix, "other", "http://t"); ok {
+		t.Error("link text of a removed link still indexed")
+	}
+
+	//so does the source
+	index(ix, "http://a", []string{"alpha"}, map[string][]string{"http://t": {"link"}})
+	ix.Remove("http://a")
+	if _, ok := find(ix, "link", "http://t"); ok {
+		t.Error("link text of a removed page still indexed")
+	}
+}
+
+func TestAnchorSourcesDontFormPhrases(t *testing.T) {
+	ix := newIndex()
+	index(ix, "http://a", nil, map[string][]string{"http://t": {"new"}})
+	index(ix, "http://b", nil, map[string][]string{"http://t": {"york"}})
+	index(ix, "http://c", nil, map[string][]string{"http://t": {"new", "york"}})
+
+	doc, _ := ix.DocID("http://t", false)
+	if n := ix.Phrase([]string{"new", "york"})[doc]; n != 1 {
+		t.Errorf("phrase found %d times, want 1 (only c's text)", n)
+	}
+
+	//without c, a and b's words cant be put together
+	index(ix, "http://c", nil, nil)
+	if n := ix.Phrase([]string{"new", "york"})[doc]; n != 0 {
+		t.Errorf("phrase across linking pages found %d times", n)
+	}
+	if d, ok := ix.Near("new", "york", 5)[doc]; ok {
+		t.Errorf("near across linking pages found at distance %d", d)
+	}
+}
+
+func TestAnchorSlotCap(t *testing.T) {
+	ix := newIndex()
+	words := make([]string, AnchorSlot+10)
+	for i := range words {
+		words[i] = "w"
+	}
+	index(ix, "http://a", nil, map[string][]string{"http://t": words})
+	p, _ := find(ix, "w", "http://t")
+	if p.Freq != AnchorSlot {
+		t.Errorf("Freq = %d, want capped at %d", p.Freq, AnchorSlot)
+	}
+}
+
+func TestSite(t *testing.T) {
+	ix := newIndex()
+	index(ix, "http://example.com/a", []string{"a"}, map[string][]string{"http://example.com/linked": {"x"}})
+	index(ix, "http://www.example.com/b", []string{"b"}, nil)
+	index(ix, "http://examples.com/c", []string{"c"}, nil)
+	index(ix, "http://example.com/gone", []string{"d"}, nil)
+	ix.Remove("http://example.com/gone")
+
+	id := func(theurl string) uint64 {
+		doc, _ := ix.DocID(theurl, false)
+		return doc
+	}
+	tests := []struct {
+		host string
+		want []string
+	}{
+		{"example.com", []string{"http://example.com/a", "http://www.example.com/b"}},
+		{"www.example.com", []string{"http://www.example.com/b"}},
+		{"EXAMPLE.com", []string{"http://example.com/a", "http://www.example.com/b"}},
+		{"com", []string{"http://example.com/a", "http://www.example.com/b", "http://examples.com/c"}},
+		{"other.com", nil},
+	}
+	for _, tt := range tests {
+		want := map[uint64]bool{}
+		for _, u := range tt.want {
+			want[id(u)] = true
+		}
+		if got := ix.Site(tt.host); !reflect.DeepEqual(got, want) {
+			t.Errorf("Site(%q) = %v, want %v", tt.host, got, want)
+		}
+	}
+
+	//rebuilding gives the same docs
+	if n := ix.IndexHosts(); n != 3 {
+		t.Errorf("IndexHosts = %d, want 3", n)
+	}
+	if got := ix.Site("example.com"); len(got) != 2 {
+		t.Errorf("Site after IndexHosts = %v", got)
+	}
+}
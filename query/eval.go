@@ -0,0 +1,247 @@
+package query
+
+/*
+	Evaluating a parsed query against one or more indexes.
+	Every node gives the urls it matches with a bm25 score, groups and ORs
+	combine those of their children. Matches are by url rather than doc id
+	so a group works across stores: an excluded url stays out even if it was
+	matched in another store. Document frequencies and the bm25 totals are
+	summed over every index so their scores are comparable.
+*/
+
+import (
+	"strings"
+
+	"../posting"
+)
+
+type evaluator struct {
+	inds    []*posting.Index
+	scorers []*posting.Scorer //one per index, on the totals of all of them
+	urls    []map[uint64]string
+	all     []map[uint64]string //every doc, loaded once for inurl: filters
+}
+
+//Eval the query against inds, giving the score of each matching url
+func (q Query) Eval(inds ...*posting.Index) map[string]float64 {
+	if q.Empty() || len(inds) == 0 {
+		return map[string]float64{}
+	}
+	corpus := posting.CorpusScorer(inds...)
+	e := &evaluator{inds: inds}
+	for _, ix := range inds {
+		e.scorers = append(e.scorers, corpus.On(ix))
+		e.urls = append(e.urls, map[uint64]string{})
+	}
+	return q.Root.eval(e)
+}
+
+//url of doc in the i'th index
+func (e *evaluator) url(i int, doc uint64) string {
+	u, ok := e.urls[i][doc]
+	if !ok {
+		u = e.inds[i].URL(doc)
+		e.urls[i][doc] = u
+	}
+	return u
+}
+
+//a url found in several stores keeps its best score rather than adding them up
+func (e *evaluator) put(out map[string]float64, i int, doc uint64, score float64) {
+	u := e.url(i, doc)
+	if u == "" {
+		return
+	}
+	if old, ok := out[u]; !ok || score > old {
+		out[u] = score
+	}
+}
+
+//words are stemmed the same as the index, so a term finds its other forms too
+func (t Term) eval(e *evaluator) map[string]float64 {
+	lists := make([][]posting.Posting, len(e.inds))
+	df := 0
+	for i, ix := range e.inds {
+		lists[i] = ix.Postings(t.Word)
+		df += len(lists[i])
+	}
+
+	out := map[string]float64{}
+	for i, hits := range lists {
+		for _, p := range hits {
+			if t.Field != 0 {
+				if p.Fields&t.Field == 0 {
+					continue
+				}
+				p = p.Only(t.Field)
+			}
+			e.put(out, i, p.Doc, e.scorers[i].Term(p, df))
+		}
+	}
+	return out
+}
+
+//phrases score like a term as many words long for each time they occur
+func (ph Phrase) eval(e *evaluator) map[string]float64 {
+	found := make([]map[uint64]int, len(e.inds))
+	df := 0
+	for i, ix := range e.inds {
+		docs := ix.Phrase(ph.Words)
+
+		//positions dont say which field they are in, so settle for every word being in it
+		if ph.Field != 0 {
+			for _, w := range nonEmpty(ph.Words) {
+				in := map[uint64]bool{}
+				for _, p := range ix.Postings(w) {
+					if p.Fields&ph.Field != 0 {
+						in[p.Doc] = true
+					}
+				}
+				for doc := range docs {
+					if !in[doc] {
+						delete(docs, doc)
+					}
+				}
+			}
+		}
+		found[i] = docs
+		df += len(docs)
+	}
+
+	out := map[string]float64{}
+	weight := float64(len(nonEmpty(ph.Words)))
+	for i, docs := range found {
+		for doc, n := range docs {
+			e.put(out, i, doc, weight*e.scorers[i].Score(doc, float64(n), df))
+		}
+	}
+	return out
+}
+
+//closer matches score higher
+func (n Near) eval(e *evaluator) map[string]float64 {
+	found := make([]map[uint64]int, len(e.inds))
+	df := 0
+	for i, ix := range e.inds {
+		found[i] = ix.Near(n.Left, n.Right, n.Distance)
+		df += len(found[i])
+	}
+
+	out := map[string]float64{}
+	for i, docs := range found {
+		for doc, d := range docs {
+			closeness := float64(n.Distance-d+1) / float64(n.Distance+1)
+			e.put(out, i, doc, 2*closeness*e.scorers[i].Score(doc, 1, df))
+		}
+	}
+	return out
+}
+
+func (e *evaluator) allUrls(i int) map[uint64]string {
+	if e.all == nil {
+		e.all = make([]map[uint64]string, len(e.inds))
+	}
+	if e.all[i] == nil {
+		e.all[i] = map[uint64]string{}
+		e.inds[i].EachDoc(func(doc uint64, u string) bool {
+			e.all[i][doc] = u
+			return true
+		})
+	}
+	return e.all[i]
+}
+
+//filters match without adding to the score
+func (s Site) eval(e *evaluator) map[string]float64 {
+	out := map[string]float64{}
+	for i, ix := range e.inds {
+		for doc := range ix.Site(s.Host) {
+			e.put(out, i, doc, 0)
+		}
+	}
+	return out
+}
+
+func (f URL) eval(e *evaluator) map[string]float64 {
+	out := map[string]float64{}
+	for i := range e.inds {
+		for _, u := range e.allUrls(i) {
+			if strings.Contains(strings.ToLower(u), f.Text) {
+				out[u] = 0
+			}
+		}
+	}
+	return out
+}
+
+func (o Or) eval(e *evaluator) map[string]float64 {
+	out := map[string]float64{}
+	for _, n := range o.Nodes {
+		for u, score := range n.eval(e) {
+			out[u] += score
+		}
+	}
+	return out
+}
+
+func (g *Group) eval(e *evaluator) map[string]float64 {
+	var out map[string]float64
+	for _, n := range g.Required {
+		matches := n.eval(e)
+		if out == nil {
+			out = matches
+		} else {
+			for u, score := range out {
+				if s, ok := matches[u]; ok {
+					out[u] = score + s
+				} else {
+					delete(out, u)
+				}
+			}
+		}
+		if len(out) == 0 {
+			return out
+		}
+	}
+
+	if len(g.Optional) > 0 {
+		any := map[string]float64{}
+		for _, n := range g.Optional {
+			for u, score := range n.eval(e) {
+				any[u] += score
+			}
+		}
+		if out == nil {
+			out = any
+		} else {
+			//only adds to the score once something is required
+			for u := range out {
+				out[u] += any[u]
+			}
+		}
+	}
+
+	//filters only narrow down the matches, or are all there is to match
+	for _, n := range g.Filters {
+		matches := n.eval(e)
+		if out == nil {
+			out = matches
+			continue
+		}
+		for u := range out {
+			if _, ok := matches[u]; !ok {
+				delete(out, u)
+			}
+		}
+	}
+	if out == nil {
+		return map[string]float64{}
+	}
+
+	for _, n := range g.Excluded {
+		for u := range n.eval(e) {
+			delete(out, u)
+		}
+	}
+	return out
+}
@@ -0,0 +1,499 @@
+package query
+
+/*
+	Search query parsing.
+	A query is a list of clauses, each optionally prefixed with + (required)
+	or - (excluded). Clauses are plain terms, "quoted phrases", (groups),
+	a OR b, word NEAR/5 word (plain NEAR means within 10 words) and field
+	filters like title:word, meta:"a phrase", site:example.com (which takes in
+	its subdomains too) or inurl:blog.
+	a AND b makes both sides required. Without a + or -, terms are optional
+	but at least one has to match, phrases and NEAR are required. site: and
+	inurl: filters only narrow down what the rest matches, they dont make
+	the terms next to them optional. A group of nothing but exclusions and
+	filters, like (-fish), applies them to the group it is in. The result
+	is an AST evaluated against the keyword index.
+	Stray parentheses and operators are skipped, but an OR with nothing
+	after it, an OR of only exclusions and -(-fish) are SyntaxErrors.
+*/
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"../posting"
+	"../tokenizer"
+)
+
+//default distance for NEAR without a number
+const DefaultDistance = 10
+
+//Node of a parsed query
+type Node interface {
+	eval(e *evaluator) map[string]float64
+}
+
+//Term is a single keyword, only in Field if it isnt 0
+type Term struct {
+	Word  string
+	Field posting.Field
+}
+
+//Phrase of words in order, "" where a word isnt indexed
+type Phrase struct {
+	Words []string
+	Field posting.Field
+}
+
+//Near clause, Left within Distance words of Right
+type Near struct {
+	Left     string
+	Right    string
+	Distance int
+}
+
+//Site matches docs on a host
+type Site struct {
+	Host string
+}
+
+//URL matches docs with Text somewhere in their url
+type URL struct {
+	Text string
+}
+
+//Or matches docs matching any of its nodes
+type Or struct {
+	Nodes []Node
+}
+
+//Group of clauses, ie the whole query or one in parentheses. Docs have to
+//match every Required node, at least one Optional node if there are no
+//Required ones, every Filter and none of the Excluded nodes.
+type Group struct {
+	Required []Node
+	Optional []Node
+	Filters  []Node //site: and inurl:, matching without adding to the score
+	Excluded []Node
+}
+
+//Query parsed
+type Query struct {
+	Root *Group
+}
+
+//SyntaxError of a query that cant be made sense of
+type SyntaxError struct {
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return "query: " + e.Msg
+}
+
+//Empty if nothing in the query can be looked up
+func (q Query) Empty() bool {
+	return q.Root == nil || !q.Root.matches()
+}
+
+//matches something by itself, rather than only filtering or excluding
+func (g *Group) matches() bool {
+	return len(g.Required)+len(g.Optional)+len(g.Filters) > 0
+}
+
+//filters narrow down the results without scoring: site:, inurl: and ORs of them
+func filter(n Node) bool {
+	switch n := n.(type) {
+	case Site, URL:
+		return true
+	case Or:
+		for _, c := range n.Nodes {
+			if !filter(c) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+//Terms looked for by the query, leaving out excluded ones
+func (q Query) Terms() []string {
+	seen := map[string]bool{}
+	terms := []string{}
+	add := func(words ...string) {
+		for _, w := range words {
+			if w != "" && !seen[w] {
+				seen[w] = true
+				terms = append(terms, w)
+			}
+		}
+	}
+
+	var walk func(n Node)
+	walk = func(n Node) {
+		switch n := n.(type) {
+		case Term:
+			add(n.Word)
+		case Phrase:
+			add(n.Words...)
+		case Near:
+			add(n.Left, n.Right)
+		case Or:
+			for _, c := range n.Nodes {
+				walk(c)
+			}
+		case *Group:
+			for _, c := range n.Required {
+				walk(c)
+			}
+			for _, c := range n.Optional {
+				walk(c)
+			}
+		}
+	}
+	if q.Root != nil {
+		walk(q.Root)
+	}
+	return terms
+}
+
+var nearOpRe = regexp.MustCompile(`^NEAR(?:/(\d+))?$`)
+
+//field prefixes, the posting field they restrict to or 0 for the url filters
+var fields = map[string]posting.Field{
+	"title": posting.Title,
+	"meta":  posting.Meta,
+	"site":  0,
+	"url":   0,
+	"inurl": 0,
+}
+
+type tokenKind int
+
+const (
+	word tokenKind = iota
+	quoted
+	open
+	closed
+)
+
+type token struct {
+	kind  tokenKind
+	mod   byte //'+', '-' or 0
+	field string
+	text  string
+}
+
+//operator words only count as operators written bare and uppercase
+func (t token) op() string {
+	if t.kind != word || t.mod != 0 || t.field != "" {
+		return ""
+	}
+	if t.text == "OR" || t.text == "AND" || nearOpRe.MatchString(t.text) {
+		return t.text
+	}
+	return ""
+}
+
+func lex(text string) []token {
+	toks := []token{}
+	rs := []rune(text)
+	i := 0
+	for i < len(rs) {
+		if unicode.IsSpace(rs[i]) {
+			i++
+			continue
+		}
+		if rs[i] == ')' {
+			toks = append(toks, token{kind: closed})
+			i++
+			continue
+		}
+
+		t := token{}
+		if (rs[i] == '+' || rs[i] == '-') && i+1 < len(rs) && !unicode.IsSpace(rs[i+1]) {
+			t.mod = byte(rs[i])
+			i++
+		}
+		//field:
+		j := i
+		for j < len(rs) && unicode.IsLetter(rs[j]) {
+			j++
+		}
+		if j > i && j < len(rs) && rs[j] == ':' {
+			name := strings.ToLower(string(rs[i:j]))
+			if _, ok := fields[name]; ok {
+				t.field = name
+				i = j + 1
+			}
+		}
+
+		switch {
+		case i < len(rs) && rs[i] == '(':
+			t.kind = open
+			i++
+		case i < len(rs) && rs[i] == '"':
+			t.kind = quoted
+			end := i + 1
+			for end < len(rs) && rs[end] != '"' {
+				end++
+			}
+			t.text = string(rs[i+1 : end])
+			i = end + 1
+		default:
+			t.kind = word
+			end := i
+			for end < len(rs) && !unicode.IsSpace(rs[end]) && !strings.ContainsRune("()\"", rs[end]) {
+				end++
+			}
+			t.text = string(rs[i:end])
+			i = end
+		}
+		toks = append(toks, t)
+	}
+	return toks
+}
+
+type parser struct {
+	toks []token
+	i    int
+	err  error //the first syntax error
+}
+
+func (p *parser) fail(format string, args ...interface{}) {
+	if p.err == nil {
+		p.err = &SyntaxError{Msg: fmt.Sprintf(format, args...)}
+	}
+}
+
+type clause struct {
+	mod  byte
+	node Node
+}
+
+//Parse a query
+func Parse(text string) (Query, error) {
+	p := &parser{toks: lex(text)}
+	root := p.group(false)
+	if p.err != nil {
+		return Query{}, p.err
+	}
+	return Query{Root: root}, nil
+}
+
+func (p *parser) peek() (token, bool) {
+	if p.i < len(p.toks) {
+		return p.toks[p.i], true
+	}
+	return token{}, false
+}
+
+//clauses up to the closing parenthesis, or the end
+func (p *parser) group(nested bool) *Group {
+	clauses := []clause{}
+	and := false
+	for {
+		t, ok := p.peek()
+		if !ok {
+			break
+		}
+		if t.kind == closed {
+			p.i++
+			if nested {
+				break
+			}
+			//stray, ignore it
+			continue
+		}
+		switch t.op() {
+		case "":
+		case "AND":
+			p.i++
+			if len(clauses) > 0 && clauses[len(clauses)-1].mod == 0 {
+				clauses[len(clauses)-1].mod = '+'
+			}
+			and = true
+			continue
+		default:
+			//OR or NEAR with nothing on the left
+			p.i++
+			continue
+		}
+
+		mod, node := p.or()
+		if node == nil {
+			continue
+		}
+		if and && mod == 0 {
+			mod = '+'
+		}
+		and = false
+		clauses = append(clauses, clause{mod, node})
+	}
+
+	g := &Group{}
+	for _, c := range clauses {
+		//(-fish) and (site:x -fish) apply to this group
+		if sub, ok := c.node.(*Group); ok && len(sub.Required)+len(sub.Optional) == 0 {
+			if c.mod != '-' {
+				g.Filters = append(g.Filters, sub.Filters...)
+				g.Excluded = append(g.Excluded, sub.Excluded...)
+				continue
+			}
+			if len(sub.Filters) == 0 {
+				p.fail("nothing to exclude in -(...)")
+				continue
+			}
+		}
+
+		switch {
+		case c.mod == '-':
+			g.Excluded = append(g.Excluded, c.node)
+		case filter(c.node):
+			g.Filters = append(g.Filters, c.node)
+		case c.mod == '+':
+			g.Required = append(g.Required, c.node)
+		default:
+			switch c.node.(type) {
+			case Phrase, Near:
+				g.Required = append(g.Required, c.node)
+			default:
+				g.Optional = append(g.Optional, c.node)
+			}
+		}
+	}
+	return g
+}
+
+//a OR b OR c, a + or - on the first applies to all of it
+func (p *parser) or() (byte, Node) {
+	mod, node := p.near()
+	nodes := []Node{}
+	if node != nil {
+		nodes = append(nodes, node)
+	}
+	for {
+		t, ok := p.peek()
+		if !ok || t.op() != "OR" {
+			break
+		}
+		p.i++
+		//the right hand side has to be something, not the end of a group or another operator
+		next, ok := p.peek()
+		if !ok || next.kind == closed || next.op() != "" {
+			p.fail("OR without anything after it")
+			break
+		}
+		if _, right := p.near(); right != nil {
+			nodes = append(nodes, right)
+		}
+	}
+	switch len(nodes) {
+	case 0:
+		return mod, nil
+	case 1:
+		return mod, nodes[0]
+	}
+	for _, n := range nodes {
+		if g, ok := n.(*Group); ok && !g.matches() {
+			p.fail("OR of a group with only exclusions")
+		}
+	}
+	return mod, Or{Nodes: nodes}
+}
+
+//a NEAR/n b. anything but a plain word on both sides falls back to separate clauses
+func (p *parser) near() (byte, Node) {
+	mod, node := p.unary()
+	t, ok := p.peek()
+	if !ok || !strings.HasPrefix(t.op(), "NEAR") {
+		return mod, node
+	}
+	left, isTerm := node.(Term)
+	if !isTerm || left.Field != 0 || p.i+1 >= len(p.toks) {
+		return mod, node
+	}
+	next := p.toks[p.i+1]
+	if next.kind != word || next.mod != 0 || next.field != "" {
+		return mod, node
+	}
+	right := Words(next.text)
+	if len(right) != 1 || right[0] == "" {
+		return mod, node
+	}
+
+	distance := DefaultDistance
+	if m := nearOpRe.FindStringSubmatch(t.op()); m[1] != "" {
+		distance, _ = strconv.Atoi(m[1])
+	}
+	p.i += 2
+	return mod, Near{Left: left.Word, Right: right[0], Distance: distance}
+}
+
+//one term, phrase, filter or group
+func (p *parser) unary() (byte, Node) {
+	t := p.toks[p.i]
+	p.i++
+
+	switch t.kind {
+	case open:
+		g := p.group(true)
+		if !g.matches() && len(g.Excluded) == 0 {
+			return t.mod, nil
+		}
+		return t.mod, g
+	case closed:
+		return 0, nil
+	}
+
+	switch t.field {
+	case "site":
+		host := strings.Trim(strings.ToLower(t.text), "./ ")
+		if host == "" {
+			return t.mod, nil
+		}
+		return t.mod, Site{Host: host}
+	case "url", "inurl":
+		text := strings.ToLower(strings.TrimSpace(t.text))
+		if text == "" {
+			return t.mod, nil
+		}
+		return t.mod, URL{Text: text}
+	}
+
+	//words split the way the crawler indexes them, so "foo-bar" is a phrase
+	words := Words(t.text)
+	for len(words) > 0 && words[0] == "" {
+		words = words[1:]
+	}
+	for len(words) > 0 && words[len(words)-1] == "" {
+		words = words[:len(words)-1]
+	}
+	switch len(words) {
+	case 0:
+		return t.mod, nil
+	case 1:
+		return t.mod, Term{Word: words[0], Field: fields[t.field]}
+	}
+	return t.mod, Phrase{Words: words, Field: fields[t.field]}
+}
+
+//Words of a text, split the same way the crawler indexes it. Words that
+//arent indexed are left as "" so positions still line up.
+func Words(text string) []string {
+	return tokenizer.Terms(text)
+}
+
+func nonEmpty(words []string) []string {
+	out := []string{}
+	for _, w := range words {
+		if w != "" {
+			out = append(out, w)
+		}
+	}
+	return out
+}
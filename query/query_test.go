@@ -0,0 +1,224 @@
+package query
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/steveyen/gkvlite"
+
+	"../posting"
+)
+
+//the indexed form of a single word
+func w(word string) string {
+	return Words(word)[0]
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		query string
+		want  *Group
+	}{
+		{"fish", &Group{Optional: []Node{Term{Word: w("fish")}}}},
+		{"+fish -cats", &Group{Required: []Node{Term{Word: w("fish")}}, Excluded: []Node{Term{Word: w("cats")}}}},
+		{`"go fish"`, &Group{Required: []Node{Phrase{Words: []string{w("go"), w("fish")}}}}},
+		{"title:fish", &Group{Optional: []Node{Term{Word: w("fish"), Field: posting.Title}}}},
+		{`meta:"go fish"`, &Group{Required: []Node{Phrase{Words: []string{w("go"), w("fish")}, Field: posting.Meta}}}},
+		{"site:Example.com.", &Group{Filters: []Node{Site{Host: "example.com"}}}},
+		{"inurl:Blog", &Group{Filters: []Node{URL{Text: "blog"}}}},
+		{"dogs site:example.com", &Group{Optional: []Node{Term{Word: w("dogs")}}, Filters: []Node{Site{Host: "example.com"}}}},
+		{"+site:example.com dogs", &Group{Optional: []Node{Term{Word: w("dogs")}}, Filters: []Node{Site{Host: "example.com"}}}},
+		{"(site:a.com OR site:b.com) dogs", &Group{
+			Optional: []Node{Term{Word: w("dogs")}},
+			Filters:  []Node{Or{Nodes: []Node{Site{Host: "a.com"}, Site{Host: "b.com"}}}},
+		}},
+		{"(-fish) dogs", &Group{Optional: []Node{Term{Word: w("dogs")}}, Excluded: []Node{Term{Word: w("fish")}}}},
+		{"dogs (site:x.com -fish)", &Group{
+			Optional: []Node{Term{Word: w("dogs")}},
+			Filters:  []Node{Site{Host: "x.com"}},
+			Excluded: []Node{Term{Word: w("fish")}},
+		}},
+		{"dogs -(site:x.com -fish)", &Group{
+			Optional: []Node{Term{Word: w("dogs")}},
+			Excluded: []Node{&Group{Filters: []Node{Site{Host: "x.com"}}, Excluded: []Node{Term{Word: w("fish")}}}},
+		}},
+		{"fish OR cats", &Group{Optional: []Node{Or{Nodes: []Node{Term{Word: w("fish")}, Term{Word: w("cats")}}}}}},
+		{"fish AND cats", &Group{Required: []Node{Term{Word: w("fish")}, Term{Word: w("cats")}}}},
+		{"fish NEAR/3 cats", &Group{Required: []Node{Near{Left: w("fish"), Right: w("cats"), Distance: 3}}}},
+		{"fish NEAR cats", &Group{Required: []Node{Near{Left: w("fish"), Right: w("cats"), Distance: DefaultDistance}}}},
+		{"fish or cats", &Group{Optional: []Node{Term{Word: w("fish")}, Term{Word: w("or")}, Term{Word: w("cats")}}}},
+		{"-(fish cats) dogs", &Group{
+			Optional: []Node{Term{Word: w("dogs")}},
+			Excluded: []Node{&Group{Optional: []Node{Term{Word: w("fish")}, Term{Word: w("cats")}}}},
+		}},
+		{"fish-cats", &Group{Required: []Node{Phrase{Words: []string{w("fish"), w("cats")}}}}},
+
+		//leniency
+		{"fish )", &Group{Optional: []Node{Term{Word: w("fish")}}}},
+		{"(fish", &Group{Optional: []Node{&Group{Optional: []Node{Term{Word: w("fish")}}}}}},
+		{"OR fish", &Group{Optional: []Node{Term{Word: w("fish")}}}},
+		{"fish AND", &Group{Required: []Node{Term{Word: w("fish")}}}},
+		{"()", &Group{}},
+		{"site:", &Group{}},
+		{`""`, &Group{}},
+	}
+	for _, tt := range tests {
+		q, err := Parse(tt.query)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(q.Root, tt.want) {
+			t.Errorf("Parse(%q) = %#v, want %#v", tt.query, q.Root, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, query := range []string{
+		"(a OR ) b",
+		"fish OR",
+		"fish OR OR cats",
+		"fish OR AND cats",
+		"-(-fish) dogs",
+		"(-fish) OR dogs",
+	} {
+		_, err := Parse(query)
+		if _, ok := err.(*SyntaxError); !ok {
+			t.Errorf("Parse(%q) err = %v, want a SyntaxError", query, err)
+		}
+	}
+}
+
+func TestEmptyAndTerms(t *testing.T) {
+	tests := []struct {
+		query string
+		empty bool
+		terms []string
+	}{
+		{"", true, []string{}},
+		{"-fish", true, []string{}},
+		{"fish -cats", false, []string{w("fish")}},
+		{`"go fish" fish`, false, []string{w("go"), w("fish")}},
+		{"a NEAR/2 b site:x.com", false, []string{w("a"), w("b")}},
+		{"site:x.com", false, []string{}},
+		{"(-fish)", true, []string{}},
+	}
+	for _, tt := range tests {
+		q, _ := Parse(tt.query)
+		if q.Empty() != tt.empty {
+			t.Errorf("%q: Empty() = %v, want %v", tt.query, q.Empty(), tt.empty)
+		}
+		if terms := q.Terms(); !reflect.DeepEqual(terms, tt.terms) {
+			t.Errorf("%q: Terms() = %v, want %v", tt.query, terms, tt.terms)
+		}
+	}
+}
+
+//a store with each url's text indexed as its body
+func store(pages map[string]string) *posting.Index {
+	s, _ := gkvlite.NewStore(nil)
+	ix := posting.Open(s)
+	for u, text := range pages {
+		doc, _ := ix.DocID(u, true)
+		b := posting.NewBatch()
+		b.Replace(doc)
+		for _, word := range Words(text) {
+			if word == "" {
+				b.Skip(doc, 1)
+			} else {
+				b.Add(doc, word, posting.Body)
+			}
+		}
+		ix.Write(b)
+	}
+	return ix
+}
+
+func eval(t *testing.T, query string, inds ...*posting.Index) map[string]float64 {
+	q, err := Parse(query)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", query, err)
+	}
+	return q.Eval(inds...)
+}
+
+func keys(m map[string]float64) map[string]bool {
+	k := map[string]bool{}
+	for u := range m {
+		k[u] = true
+	}
+	return k
+}
+
+func TestEval(t *testing.T) {
+	ix := store(map[string]string{
+		"http://a.example.com/1": "go fish in the sea",
+		"http://b.example.com/2": "cats fish and dogs",
+		"http://other.com/3":     "cats and dogs",
+	})
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"fish", []string{"http://a.example.com/1", "http://b.example.com/2"}},
+		{"fish -cats", []string{"http://a.example.com/1"}},
+		{"+cats +dogs", []string{"http://b.example.com/2", "http://other.com/3"}},
+		{`"go fish"`, []string{"http://a.example.com/1"}},
+		{`"fish go"`, []string{}},
+		{"cats NEAR/3 dogs", []string{"http://b.example.com/2", "http://other.com/3"}},
+		{"cats NEAR/2 dogs", []string{"http://other.com/3"}},
+		{"+dogs site:example.com", []string{"http://b.example.com/2"}},
+		{"dogs site:example.com", []string{"http://b.example.com/2"}},
+		{"sea inurl:example", []string{"http://a.example.com/1"}},
+		{"cats inurl:other", []string{"http://other.com/3"}},
+		{"site:example.com", []string{"http://a.example.com/1", "http://b.example.com/2"}},
+		{"(site:other.com OR site:b.example.com) dogs", []string{"http://b.example.com/2", "http://other.com/3"}},
+		{"(-fish) dogs", []string{"http://other.com/3"}},
+		{"dogs -(site:example.com -fish)", []string{"http://b.example.com/2", "http://other.com/3"}},
+		{"sea OR cats", []string{"http://a.example.com/1", "http://b.example.com/2", "http://other.com/3"}},
+	}
+	for _, tt := range tests {
+		want := map[string]bool{}
+		for _, u := range tt.want {
+			want[u] = true
+		}
+		if got := keys(eval(t, tt.query, ix)); !reflect.DeepEqual(got, want) {
+			t.Errorf("%q matched %v, want %v", tt.query, got, want)
+		}
+	}
+}
+
+func TestEvalAcrossStores(t *testing.T) {
+	one := store(map[string]string{"http://x.com": "fish"})
+	two := store(map[string]string{"http://x.com": "fish cats", "http://y.com": "fish"})
+
+	//excluded in one store, so out of the results from the other too
+	got := eval(t, "fish -cats", one, two)
+	if _, ok := got["http://x.com"]; ok {
+		t.Errorf("excluded url matched: %v", got)
+	}
+	if _, ok := got["http://y.com"]; !ok {
+		t.Errorf("y.com missing: %v", got)
+	}
+
+	//required terms can be found in different stores
+	if got := eval(t, "+fish +cats", one, two); len(got) != 1 {
+		t.Errorf("+fish +cats matched %v", got)
+	}
+}
+
+func TestScoresComparableAcrossStores(t *testing.T) {
+	//the same page scores the same whichever store it is in
+	small := store(map[string]string{"http://a.com": "fish"})
+	big := store(map[string]string{
+		"http://b.com": "fish",
+		"http://c.com": "cats",
+		"http://d.com": "dogs",
+		"http://e.com": "birds",
+	})
+	got := eval(t, "fish", small, big)
+	if got["http://a.com"] != got["http://b.com"] || got["http://a.com"] <= 0 {
+		t.Errorf("scores differ between stores: %v", got)
+	}
+}
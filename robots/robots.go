@@ -0,0 +1,403 @@
+package robots
+
+/*
+	robots.txt fetching, parsing and caching.
+	Rules are cached per scheme://host in a gkvlite collection along with
+	the time they were fetched, and refetched once they expire. A host whose
+	robots.txt errors is kept out until it can be tried again, which callers
+	can tell apart from a disallow with Unavailable.
+*/
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/steveyen/gkvlite"
+)
+
+//how long fetched rules are trusted before going back to the host
+var Expiry = 24 * time.Hour
+
+//how long to hold off a host whose robots.txt errored (5xx, timeouts)
+var ErrorExpiry = 1 * time.Hour
+
+//cap on how much of a robots.txt we bother reading
+const maxBodyBytes = 512 * 1024
+
+type rule struct {
+	allow bool
+	path  string
+}
+
+type group struct {
+	agents     []string
+	rules      []rule
+	crawlDelay time.Duration
+}
+
+//Rules is a parsed robots.txt
+type Rules struct {
+	Sitemaps []string
+
+	groups   []*group
+	disallow bool //blanket disallow, used when the host errored
+}
+
+//Parse a robots.txt body. Unknown lines are ignored.
+func Parse(body []byte) *Rules {
+	r := &Rules{}
+
+	var cur *group
+	inagents := false
+
+	lines := strings.Split(string(body), "\n")
+	for _, line := range lines {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:i]))
+		val := strings.TrimSpace(line[i+1:])
+
+		switch key {
+		case "user-agent":
+			//consecutive user-agent lines share a group
+			if cur == nil || !inagents {
+				cur = &group{}
+				r.groups = append(r.groups, cur)
+			}
+			cur.agents = append(cur.agents, strings.ToLower(val))
+			inagents = true
+
+		case "allow", "disallow":
+			inagents = false
+			if cur == nil {
+				continue
+			}
+			//empty disallow means allow everything, nothing to record
+			if val == "" {
+				continue
+			}
+			cur.rules = append(cur.rules, rule{allow: key == "allow", path: val})
+
+		case "crawl-delay":
+			inagents = false
+			if cur == nil {
+				continue
+			}
+			secs, err := strconv.ParseFloat(val, 64)
+			if err == nil && secs > 0 {
+				cur.crawlDelay = time.Duration(secs * float64(time.Second))
+			}
+
+		case "sitemap":
+			//not tied to any group
+			inagents = false
+			if val != "" {
+				r.Sitemaps = append(r.Sitemaps, val)
+			}
+
+		default:
+			inagents = false
+		}
+	}
+
+	return r
+}
+
+//Product token of a user-agent, "gofish" of "gofish/0.1 (+https://...)"
+func Product(agent string) string {
+	agent = strings.ToLower(strings.TrimSpace(agent))
+	if i := strings.IndexAny(agent, "/ \t("); i >= 0 {
+		agent = agent[:i]
+	}
+	return agent
+}
+
+//how well a group's user-agent line names the agent, 0 if it doesnt
+func specificity(name string, agent string, product string) int {
+	if name == "" || product == "" {
+		return 0
+	}
+	if name == "*" {
+		return 1
+	}
+	//a name with a version, ie gofish/0.1, beats the bare product token but only for that version
+	if strings.Contains(name, "/") {
+		if strings.HasPrefix(agent, name) {
+			return 3
+		}
+		return 0
+	}
+	if Product(name) == product {
+		return 2
+	}
+	return 0
+}
+
+//find the most specific group for the agent, falling back to *
+func (r *Rules) group(agent string) *group {
+	agent = strings.ToLower(strings.TrimSpace(agent))
+	product := Product(agent)
+
+	var best *group
+	bestScore := 0
+	for _, g := range r.groups {
+		for _, a := range g.agents {
+			if s := specificity(a, agent, product); s > bestScore {
+				best, bestScore = g, s
+			}
+		}
+	}
+	return best
+}
+
+//Allowed reports whether agent may fetch the given path (with query)
+func (r *Rules) Allowed(agent string, path string) bool {
+	if r.disallow {
+		return false
+	}
+	g := r.group(agent)
+	if g == nil {
+		return true
+	}
+	if path == "" {
+		path = "/"
+	}
+
+	//longest match wins, allow wins ties
+	best := -1
+	allowed := true
+	for _, ru := range g.rules {
+		if !match(ru.path, path) {
+			continue
+		}
+		l := len(ru.path)
+		if l > best || (l == best && ru.allow) {
+			best = l
+			allowed = ru.allow
+		}
+	}
+	return allowed
+}
+
+//CrawlDelay for the agent, zero if not set
+func (r *Rules) CrawlDelay(agent string) time.Duration {
+	g := r.group(agent)
+	if g == nil {
+		return 0
+	}
+	return g.crawlDelay
+}
+
+//match a robots path pattern supporting * and a trailing $
+func match(pattern string, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	if anchored {
+		pattern = pattern[:len(pattern)-1]
+	}
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	pos := len(parts[0])
+	for _, p := range parts[1:] {
+		i := strings.Index(path[pos:], p)
+		if i < 0 {
+			return false
+		}
+		pos += i + len(p)
+	}
+
+	if anchored {
+		//the last piece has to land on the end of the path
+		last := parts[len(parts)-1]
+		if len(parts) == 1 {
+			return pos == len(path)
+		}
+		return strings.HasSuffix(path, last)
+	}
+	return true
+}
+
+//Cache of rules per host, backed by a gkvlite collection
+type Cache struct {
+	Agent  string
+	Client *http.Client
+
+	coll *gkvlite.Collection
+	mu   sync.Mutex
+	mem  map[string]*cached
+}
+
+type cached struct {
+	rules   *Rules
+	expires time.Time
+	loading chan bool //closed once the fetch in flight is done, nil otherwise
+}
+
+//NewCache using coll to persist fetched robots.txt files
+func NewCache(coll *gkvlite.Collection, agent string) *Cache {
+	return &Cache{
+		Agent:  agent,
+		Client: http.DefaultClient,
+		coll:   coll,
+		mem:    map[string]*cached{},
+	}
+}
+
+//Allowed reports whether theurl may be fetched according to its host's robots.txt
+func (c *Cache) Allowed(theurl string) bool {
+	u, err := url.Parse(theurl)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	r := c.Rules(u)
+	return r.Allowed(c.Agent, u.RequestURI())
+}
+
+//CrawlDelay requested by theurl's host, zero if none
+func (c *Cache) CrawlDelay(theurl string) time.Duration {
+	u, err := url.Parse(theurl)
+	if err != nil || u.Host == "" {
+		return 0
+	}
+	return c.Rules(u).CrawlDelay(c.Agent)
+}
+
+//Unavailable reports whether theurl's host's robots.txt couldnt be fetched (5xx, timeouts),
+//and when it will be tried again. Allowed is false until then, but it isnt a disallow.
+func (c *Cache) Unavailable(theurl string) (bool, time.Time) {
+	u, err := url.Parse(theurl)
+	if err != nil || u.Host == "" {
+		return false, time.Time{}
+	}
+	r, expires := c.lookup(u)
+	return r.disallow, expires
+}
+
+//Sitemaps listed in theurl's host's robots.txt
+func (c *Cache) Sitemaps(theurl string) []string {
+	u, err := url.Parse(theurl)
+	if err != nil || u.Host == "" {
+		return nil
+	}
+	return c.Rules(u).Sitemaps
+}
+
+//Rules for the url's host, fetching robots.txt if not cached or expired
+func (c *Cache) Rules(u *url.URL) *Rules {
+	r, _ := c.lookup(u)
+	return r
+}
+
+//rules for the url's host and when they expire
+func (c *Cache) lookup(u *url.URL) (*Rules, time.Time) {
+	scheme := u.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	host := scheme + "://" + u.Host
+
+	c.mu.Lock()
+	for {
+		m, ok := c.mem[host]
+		if !ok {
+			break
+		}
+		//another thread is fetching this host, wait for it rather than fetching twice
+		if m.loading != nil {
+			loading := m.loading
+			c.mu.Unlock()
+			<-loading
+			c.mu.Lock()
+			continue
+		}
+		if time.Now().Before(m.expires) {
+			c.mu.Unlock()
+			return m.rules, m.expires
+		}
+		break
+	}
+
+	//stored format: expiry unix time, newline, raw robots.txt (or "!" for error)
+	val, err := c.coll.Get([]byte(host))
+	if err == nil && val != nil {
+		parts := strings.SplitN(string(val), "\n", 2)
+		t, err := strconv.ParseInt(parts[0], 10, 64)
+		if err == nil && len(parts) == 2 && time.Now().Before(time.Unix(t, 0)) {
+			m := &cached{rules: decode(parts[1]), expires: time.Unix(t, 0)}
+			c.mem[host] = m
+			c.mu.Unlock()
+			return m.rules, m.expires
+		}
+	}
+
+	//fetch without the lock so other hosts arent held up
+	m := &cached{loading: make(chan bool)}
+	c.mem[host] = m
+	c.mu.Unlock()
+
+	body, expiry := c.fetch(host)
+	expires := time.Now().Add(expiry)
+
+	c.mu.Lock()
+	c.coll.Set([]byte(host), []byte(strconv.FormatInt(expires.Unix(), 10)+"\n"+body))
+	m.rules = decode(body)
+	m.expires = expires
+	close(m.loading)
+	m.loading = nil
+	c.mu.Unlock()
+	return m.rules, expires
+}
+
+func decode(body string) *Rules {
+	if body == "!" {
+		return &Rules{disallow: true}
+	}
+	return Parse([]byte(body))
+}
+
+//grab robots.txt for host. 4xx means no rules, 5xx and errors mean stay away for a while
+func (c *Cache) fetch(host string) (string, time.Duration) {
+	fmt.Println("Fetching robots.txt: " + host)
+
+	req, err := http.NewRequest("GET", host+"/robots.txt", nil)
+	if err != nil {
+		return "", Expiry
+	}
+	req.Header.Set("User-Agent", c.Agent)
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		fmt.Println("Err-Robots: ", err)
+		return "!", ErrorExpiry
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		fmt.Println("Err-Robots: ", resp.Status)
+		return "!", ErrorExpiry
+	}
+	if resp.StatusCode >= 400 {
+		return "", Expiry
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBodyBytes))
+	if err != nil && len(body) == 0 {
+		return "", Expiry
+	}
+	return string(body), Expiry
+}
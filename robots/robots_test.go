@@ -0,0 +1,202 @@
+package robots
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/steveyen/gkvlite"
+)
+
+const agent = "gofish/0.1 (+https://github.com/blamarche/gofish)"
+
+func TestProduct(t *testing.T) {
+	tests := []struct {
+		agent string
+		want  string
+	}{
+		{agent, "gofish"},
+		{"GoFish", "gofish"},
+		{"Mozilla/5.0 (compatible; gofish)", "mozilla"},
+		{"gofish (bot)", "gofish"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := Product(tt.agent); got != tt.want {
+			t.Errorf("Product(%q) = %q, want %q", tt.agent, got, tt.want)
+		}
+	}
+}
+
+func TestGroupMatching(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		path  string
+		allow bool
+	}{
+		{"star only", "User-agent: *\nDisallow: /private", "/private/x", false},
+		{"no groups", "", "/private", true},
+		{"product token", "User-agent: *\nDisallow: /\n\nUser-agent: gofish\nDisallow: /private", "/public", true},
+		{"case insensitive", "User-agent: *\nDisallow: /\n\nUser-agent: GoFish\nAllow: /", "/x", true},
+		{"go is not gofish", "User-agent: go\nDisallow: /\n\nUser-agent: *\nAllow: /", "/x", true},
+		{"github in the url is not a match", "User-agent: github\nDisallow: /", "/x", true},
+		{"http in the url is not a match", "User-agent: http\nDisallow: /", "/x", true},
+		{"versioned beats product", "User-agent: gofish\nDisallow: /\n\nUser-agent: gofish/0.1\nAllow: /", "/x", true},
+		{"product beats star whatever the order", "User-agent: gofish\nDisallow: /a\n\nUser-agent: *\nDisallow: /", "/b", true},
+		{"other version", "User-agent: gofish/0.2\nDisallow: /\n\nUser-agent: *\nAllow: /", "/x", true},
+		{"shared group", "User-agent: otherbot\nUser-agent: gofish\nDisallow: /x", "/x", false},
+	}
+	for _, tt := range tests {
+		r := Parse([]byte(tt.body))
+		if got := r.Allowed(agent, tt.path); got != tt.allow {
+			t.Errorf("%s: Allowed(%q) = %v, want %v", tt.name, tt.path, got, tt.allow)
+		}
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	body := "User-agent: *\nDisallow: /a\nAllow: /a/b\nDisallow: /*.pdf$\nDisallow: /c*d\n"
+	tests := []struct {
+		path  string
+		allow bool
+	}{
+		{"/", true},
+		{"/a", false},
+		{"/a/c", false},
+		{"/a/b", true},
+		{"/x.pdf", false},
+		{"/x.pdf?q=1", true},
+		{"/c/x/d", false},
+		{"/c", true},
+	}
+	r := Parse([]byte(body))
+	for _, tt := range tests {
+		if got := r.Allowed(agent, tt.path); got != tt.allow {
+			t.Errorf("Allowed(%q) = %v, want %v", tt.path, got, tt.allow)
+		}
+	}
+}
+
+func TestCrawlDelay(t *testing.T) {
+	r := Parse([]byte("User-agent: *\nCrawl-delay: 2\n\nUser-agent: gofish\nCrawl-delay: 0.5"))
+	if got := r.CrawlDelay(agent); got != 500*time.Millisecond {
+		t.Errorf("CrawlDelay = %v, want 500ms", got)
+	}
+	if got := r.CrawlDelay("otherbot"); got != 2*time.Second {
+		t.Errorf("CrawlDelay(otherbot) = %v, want 2s", got)
+	}
+}
+
+func TestCacheFetchesOncePerHost(t *testing.T) {
+	var mu sync.Mutex
+	fetches := map[string]int{}
+	slow := make(chan bool)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		fetches[req.Host]++
+		mu.Unlock()
+		if strings.HasPrefix(req.Host, "slow.") {
+			<-slow
+		}
+		w.Write([]byte("User-agent: *\nDisallow: /private"))
+	}))
+	defer srv.Close()
+
+	//every host resolves to the test server
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	transport := &http.Transport{DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return net.Dial(network, srv.Listener.Addr().String())
+	}}
+	store, _ := gkvlite.NewStore(nil)
+	c := NewCache(store.SetCollection("robots", nil), agent)
+	c.Client = &http.Client{Transport: transport}
+
+	slowURL := "http://slow.test:" + port + "/private"
+	fastURL := "http://fast.test:" + port + "/private"
+
+	//a slow host doesnt hold up the others
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if c.Allowed(slowURL) {
+				t.Error("slow host allowed /private")
+			}
+		}()
+	}
+	done := make(chan bool)
+	go func() {
+		if c.Allowed(fastURL) {
+			t.Error("fast host allowed /private")
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("fast host waited on the slow one")
+	}
+	close(slow)
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	for host, n := range fetches {
+		if n != 1 {
+			t.Errorf("%s fetched %d times, want 1", host, n)
+		}
+	}
+}
+
+func TestCacheUnavailable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case strings.HasPrefix(req.Host, "down."):
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case strings.HasPrefix(req.Host, "missing."):
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.Write([]byte("User-agent: *\nDisallow: /private"))
+		}
+	}))
+	defer srv.Close()
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	transport := &http.Transport{DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return net.Dial(network, srv.Listener.Addr().String())
+	}}
+	store, _ := gkvlite.NewStore(nil)
+	c := NewCache(store.SetCollection("robots", nil), agent)
+	c.Client = &http.Client{Transport: transport}
+
+	tests := []struct {
+		host        string
+		path        string
+		unavailable bool
+		allowed     bool
+	}{
+		{"down.test", "/x", true, false},
+		{"missing.test", "/private", false, true},
+		{"up.test", "/private", false, false},
+		{"up.test", "/public", false, true},
+	}
+	for _, tt := range tests {
+		theurl := "http://" + tt.host + ":" + port + tt.path
+		unavailable, until := c.Unavailable(theurl)
+		if unavailable != tt.unavailable {
+			t.Errorf("Unavailable(%s) = %v, want %v", theurl, unavailable, tt.unavailable)
+		}
+		if unavailable && (until.Before(time.Now()) || until.After(time.Now().Add(ErrorExpiry+time.Minute))) {
+			t.Errorf("Unavailable(%s) until %v, want about %v from now", theurl, until, ErrorExpiry)
+		}
+		if got := c.Allowed(theurl); got != tt.allowed {
+			t.Errorf("Allowed(%s) = %v, want %v", theurl, got, tt.allowed)
+		}
+	}
+}
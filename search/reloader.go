@@ -0,0 +1,215 @@
+package search
+
+/*
+	A Searcher that follows the stores in a directory.
+	Stores are opened once and shared by every query. The directory is
+	polled and a file that is added or written to is opened once it hasnt
+	been modified for Settle, so a flush in progress isnt read half way.
+	Settle is kept well under how often the crawler flushes, which would
+	otherwise never leave the file alone long enough. A store that doesnt
+	open keeps being searched as it was. Only the files that changed are
+	reopened; a new Searcher over those and the unchanged ones is swapped
+	in, and the replaced files are closed once the queries running on them
+	are done.
+*/
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/steveyen/gkvlite"
+)
+
+//ErrClosed is returned searching a closed Reloader
+var ErrClosed = errors.New("searcher closed")
+
+//DefaultSettle is how long a file has to be left alone before it is reopened
+const DefaultSettle = time.Second
+
+//size and modification time of a file
+type fileState struct {
+	size int64
+	mod  time.Time
+}
+
+//an opened store and the state of its file when it was opened
+type openStore struct {
+	state fileState
+	file  *os.File
+	store *gkvlite.Store
+}
+
+//Reloader of the stores in a directory
+type Reloader struct {
+	Dir    string
+	Settle time.Duration //how long since a file was modified before it is reopened
+
+	reloading sync.Mutex
+	mu        sync.RWMutex
+	current   *Searcher
+	open      map[string]*openStore
+	stop      chan bool
+	stopOnce  sync.Once
+}
+
+//NewReloader with the stores in dir opened
+func NewReloader(dir string) (*Reloader, error) {
+	r := &Reloader{Dir: dir, Settle: DefaultSettle, stop: make(chan bool), open: map[string]*openStore{}}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+//states of the stores in the directory
+func (r *Reloader) scan() (map[string]fileState, error) {
+	files, err := ioutil.ReadDir(r.Dir)
+	if err != nil {
+		return nil, err
+	}
+	states := map[string]fileState{}
+	for _, fi := range files {
+		if fi.IsDir() || !IsStore(fi.Name()) {
+			continue
+		}
+		states[fi.Name()] = fileState{fi.Size(), fi.ModTime()}
+	}
+	return states, nil
+}
+
+func openFile(path string, state fileState) (*openStore, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	store, err := gkvlite.NewStore(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &openStore{state: state, file: f, store: store}, nil
+}
+
+//Reload the stores whose files changed. Returns true if a new Searcher was swapped in.
+func (r *Reloader) Reload() (bool, error) {
+	r.reloading.Lock()
+	defer r.reloading.Unlock()
+	select {
+	case <-r.stop:
+		return false, ErrClosed
+	default:
+	}
+
+	states, err := r.scan()
+	if err != nil {
+		return false, err
+	}
+
+	next := map[string]*openStore{}
+	closing := []*openStore{}
+	first := r.current == nil
+	changed := first
+	for name, state := range states {
+		old, ok := r.open[name]
+		if ok && old.state == state {
+			next[name] = old
+			continue
+		}
+		//still being written, try again next time. nothing to fall back on the first time though
+		if !first && time.Since(state.mod) < r.Settle {
+			if old != nil {
+				next[name] = old
+			}
+			continue
+		}
+		opened, err := openFile(filepath.Join(r.Dir, name), state)
+		if err != nil {
+			//keep searching the last good copy
+			if old != nil {
+				next[name] = old
+			}
+			continue
+		}
+		next[name] = opened
+		if old != nil {
+			closing = append(closing, old)
+		}
+		changed = true
+	}
+	for name, old := range r.open {
+		if _, ok := states[name]; !ok {
+			closing = append(closing, old)
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+
+	//same order every time so ties between stores break the same way
+	names := make([]string, 0, len(next))
+	for name := range next {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	stores := make([]*gkvlite.Store, 0, len(names))
+	for _, name := range names {
+		stores = append(stores, next[name].store)
+	}
+
+	//waits for searches on the old stores to finish
+	r.mu.Lock()
+	r.current = New(stores...)
+	r.open = next
+	r.mu.Unlock()
+
+	for _, old := range closing {
+		old.file.Close()
+	}
+	return true, nil
+}
+
+//Watch the directory every interval until Close, calling onError with reload errors if it isnt nil
+func (r *Reloader) Watch(interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			if _, err := r.Reload(); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+//Search the current stores
+func (r *Reloader) Search(text string, opts Options) ([]Result, Stats, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.current == nil {
+		return nil, Stats{}, ErrClosed
+	}
+	return r.current.Search(text, opts)
+}
+
+//Close the stores and stop watching
+func (r *Reloader) Close() {
+	r.stopOnce.Do(func() { close(r.stop) })
+	r.reloading.Lock()
+	defer r.reloading.Unlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, o := range r.open {
+		o.file.Close()
+	}
+	r.open = map[string]*openStore{}
+	r.current = nil
+}
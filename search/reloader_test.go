@@ -0,0 +1,144 @@
+package search
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/steveyen/gkvlite"
+)
+
+//write a store to path, stamped with mod so the change is seen whatever the clock resolution
+func writeStore(t *testing.T, path string, key string, mod time.Time) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := gkvlite.NewStore(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetCollection("title", nil).Set([]byte(key), []byte(key))
+	store.Flush()
+	f.Close()
+	os.Chtimes(path, mod, mod)
+}
+
+func TestIsStore(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"db.gkv", true},
+		{"old.gkv", true},
+		{"_tmp.gkv", false},
+		{"db.gkv.bak", false},
+		{"db.gkv-journal", false},
+		{"db.txt", false},
+	}
+	for _, tt := range tests {
+		if got := IsStore(tt.name); got != tt.want {
+			t.Errorf("IsStore(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	settled := time.Now().Add(-time.Hour)
+	writeStore(t, filepath.Join(dir, "a.gkv"), "a", settled)
+	writeStore(t, filepath.Join(dir, "_tmp.gkv"), "tmp", settled)
+	writeStore(t, filepath.Join(dir, "a.gkv.bak"), "bak", settled)
+
+	r, err := NewReloader(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stores := func() int {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		return len(r.current.sources)
+	}
+	if n := stores(); n != 1 {
+		t.Fatalf("opened %d stores, want only a.gkv", n)
+	}
+	first := r.open["a.gkv"]
+
+	b := filepath.Join(dir, "b.gkv")
+	steps := []struct {
+		name    string
+		change  func()
+		swapped bool
+		stores  int
+	}{
+		{"nothing changed", func() {}, false, 1},
+		{"new store being written", func() { writeStore(t, b, "b", time.Now()) }, false, 1},
+		{"new store settled", func() { os.Chtimes(b, settled, settled) }, true, 2},
+		{"written again", func() { writeStore(t, b, "b2", time.Now()) }, false, 2},
+		{"settled again", func() { os.Chtimes(b, settled.Add(time.Minute), settled.Add(time.Minute)) }, true, 2},
+		{"compacting", func() { writeStore(t, filepath.Join(dir, "_tmp.gkv"), "tmp2", settled.Add(time.Minute)) }, false, 2},
+		{"removed", func() { os.Remove(b) }, true, 1},
+	}
+	for _, s := range steps {
+		s.change()
+		swapped, err := r.Reload()
+		if err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		if swapped != s.swapped || stores() != s.stores {
+			t.Errorf("%s: swapped %v with %d stores, want %v with %d", s.name, swapped, stores(), s.swapped, s.stores)
+		}
+	}
+	if r.open["a.gkv"] != first {
+		t.Error("unchanged a.gkv was reopened")
+	}
+
+	r.Close()
+	if _, err := r.Reload(); err != ErrClosed {
+		t.Errorf("Reload after Close: %v, want ErrClosed", err)
+	}
+	if _, _, err := r.Search("a", Options{}); err != ErrClosed {
+		t.Errorf("Search after Close: %v, want ErrClosed", err)
+	}
+}
+
+//the crawler flushes its store more often than the server polls, each poll should still pick up the last flush
+func TestReloaderFlushedEveryPoll(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "db.gkv")
+	writeStore(t, path, "0", time.Now())
+
+	r, err := NewReloader(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.Settle = 20 * time.Millisecond
+
+	for i := 1; i <= 5; i++ {
+		//a flush finished a little while before the poll
+		writeStore(t, path, strconv.Itoa(i), time.Now())
+		time.Sleep(2 * r.Settle)
+		swapped, err := r.Reload()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !swapped {
+			t.Fatalf("flush %d not picked up", i)
+		}
+		if got := r.open["db.gkv"].state.mod; !got.Equal(mustStat(t, path).ModTime()) {
+			t.Errorf("flush %d: opened the copy from %v", i, got)
+		}
+	}
+}
+
+func mustStat(t *testing.T, path string) os.FileInfo {
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fi
+}
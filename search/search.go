@@ -0,0 +1,259 @@
+package search
+
+/*
+	Searching the crawler's gkv files.
+	A Searcher holds the index, title and meta collections of every store and
+	runs parsed queries over all of them together, scoring by url.
+*/
+
+import (
+	"container/heap"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/steveyen/gkvlite"
+
+	"../posting"
+	"../query"
+	"../snippet"
+)
+
+//ErrEmptyQuery is returned for queries with nothing that can be looked up
+var ErrEmptyQuery = errors.New("nothing to search for")
+
+//BadQuery if err is down to the query rather than the stores
+func BadQuery(err error) bool {
+	var syntax *query.SyntaxError
+	return err == ErrEmptyQuery || errors.As(err, &syntax)
+}
+
+//Options for a search
+type Options struct {
+	Offset int //results to skip
+	Limit  int //most results to return, 0 for all
+}
+
+//Result of a search
+type Result struct {
+	URL         string
+	Title       string
+	Description string
+	Score       float64
+	Terms       []string        //query terms found in the doc
+	Snippet     snippet.Snippet //best part of the page text for the query
+}
+
+//Stats about a search
+type Stats struct {
+	Total   int //results before the limit
+	Elapsed time.Duration
+}
+
+//one store's collections
+type source struct {
+	index *posting.Index
+	meta  *gkvlite.Collection
+	title *gkvlite.Collection
+	text  *gkvlite.Collection
+}
+
+//Searcher over one or more stores
+type Searcher struct {
+	sources []source
+	files   []*os.File
+}
+
+//New Searcher over already opened stores
+func New(stores ...*gkvlite.Store) *Searcher {
+	s := &Searcher{}
+	for _, store := range stores {
+		s.sources = append(s.sources, source{
+			index: posting.Open(store),
+			meta:  store.SetCollection("meta", nil),
+			title: store.SetCollection("title", nil),
+			text:  store.SetCollection("page-text", nil),
+		})
+	}
+	return s
+}
+
+//IsStore if name is a store to search: a .gkv file other than the crawler's _tmp.gkv from compacting
+func IsStore(name string) bool {
+	return strings.HasSuffix(name, ".gkv") && !strings.HasSuffix(name, "_tmp.gkv")
+}
+
+//Open every store in dir
+func Open(dir string) (*Searcher, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	stores := []*gkvlite.Store{}
+	opened := []*os.File{}
+	for _, fi := range files {
+		if fi.IsDir() || !IsStore(fi.Name()) {
+			continue
+		}
+		f, err := os.Open(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			continue
+		}
+		store, err := gkvlite.NewStore(f)
+		if err != nil {
+			f.Close()
+			continue
+		}
+		stores = append(stores, store)
+		opened = append(opened, f)
+	}
+
+	s := New(stores...)
+	s.files = opened
+	return s, nil
+}
+
+//Close the files opened by Open
+func (s *Searcher) Close() {
+	for _, f := range s.files {
+		f.Close()
+	}
+	s.files = nil
+}
+
+//Search for a query, best results first
+func (s *Searcher) Search(text string, opts Options) ([]Result, Stats, error) {
+	start := time.Now()
+
+	q, err := query.Parse(text)
+	if err != nil {
+		return nil, Stats{}, err
+	}
+	if q.Empty() {
+		return nil, Stats{}, ErrEmptyQuery
+	}
+	terms := q.Terms()
+
+	//score matches over every index at once
+	inds := make([]*posting.Index, len(s.sources))
+	for i, src := range s.sources {
+		inds[i] = src.index
+	}
+	scores := q.Eval(inds...)
+
+	//with a limit only the best offset+limit are kept, in a heap, rather than sorting everything
+	keep := len(scores)
+	if opts.Limit > 0 && opts.Offset+opts.Limit < keep {
+		keep = opts.Offset + opts.Limit
+	}
+	top := &resultHeap{}
+	for u, score := range scores {
+		r := Result{URL: u, Score: score}
+		if top.Len() == keep {
+			if keep == 0 || !better(r, (*top)[0]) {
+				continue
+			}
+			heap.Pop(top)
+		}
+		heap.Push(top, r)
+	}
+	results := []Result(*top)
+	sort.Slice(results, func(i, j int) bool {
+		return better(results[i], results[j])
+	})
+
+	stats := Stats{Total: len(scores)}
+	if opts.Offset > 0 {
+		if opts.Offset >= len(results) {
+			results = results[:0]
+		} else {
+			results = results[opts.Offset:]
+		}
+	}
+	if opts.Limit > 0 && len(results) > opts.Limit {
+		results = results[:opts.Limit]
+	}
+
+	//only look up terms, titles, descriptions and text for what is returned
+	lists := map[string][][]posting.Posting{}
+	for _, term := range terms {
+		for _, src := range s.sources {
+			lists[term] = append(lists[term], src.index.Postings(term))
+		}
+	}
+	for i := range results {
+		r := &results[i]
+		for _, term := range terms {
+			if s.has(r.URL, lists[term]) {
+				r.Terms = append(r.Terms, term)
+			}
+		}
+		r.Title = s.lookup(r.URL, func(src source) *gkvlite.Collection { return src.title })
+		r.Description = s.lookup(r.URL, func(src source) *gkvlite.Collection { return src.meta })
+
+		text := r.Description
+		if stored := s.lookup(r.URL, func(src source) *gkvlite.Collection { return src.text }); stored != "" {
+			if t, err := snippet.Decompress([]byte(stored)); err == nil && strings.TrimSpace(t) != "" {
+				text = t
+			}
+		}
+		r.Snippet = snippet.Best(text, terms)
+	}
+
+	stats.Elapsed = time.Since(start)
+	return results, stats, nil
+}
+
+//better ranks first, ties by url so pages are stable
+func better(a Result, b Result) bool {
+	if a.Score != b.Score {
+		return a.Score > b.Score
+	}
+	return a.URL < b.URL
+}
+
+//min-heap with the worst kept result on top
+type resultHeap []Result
+
+func (h resultHeap) Len() int            { return len(h) }
+func (h resultHeap) Less(i, j int) bool  { return better(h[j], h[i]) }
+func (h resultHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *resultHeap) Push(x interface{}) { *h = append(*h, x.(Result)) }
+func (h *resultHeap) Pop() interface{} {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
+
+//whether the url is in any store's postings, lists having one per store
+func (s *Searcher) has(u string, lists [][]posting.Posting) bool {
+	for i, src := range s.sources {
+		doc, ok := src.index.DocID(u, false)
+		if !ok {
+			continue
+		}
+		list := lists[i]
+		j := sort.Search(len(list), func(j int) bool { return list[j].Doc >= doc })
+		if j < len(list) && list[j].Doc == doc {
+			return true
+		}
+	}
+	return false
+}
+
+//first value for the url in any store
+func (s *Searcher) lookup(u string, coll func(source) *gkvlite.Collection) string {
+	for _, src := range s.sources {
+		val, err := coll(src).Get([]byte(u))
+		if err == nil && val != nil {
+			return string(val)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,107 @@
+package search
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/steveyen/gkvlite"
+
+	"../posting"
+)
+
+//a store of pages saying "fish" between 1 and 3 times, some of them the same so scores tie
+func testStore(prefix string, pages int) *gkvlite.Store {
+	store, _ := gkvlite.NewStore(nil)
+	ix := posting.Open(store)
+	titles := store.SetCollection("title", nil)
+	for i := 0; i < pages; i++ {
+		u := "http://" + prefix + "/" + strconv.Itoa(i)
+		doc, _ := ix.DocID(u, true)
+		b := posting.NewBatch()
+		b.Replace(doc)
+		for n := 0; n <= i%3; n++ {
+			b.Add(doc, "fish", posting.Body)
+		}
+		b.Add(doc, "other", posting.Body)
+		ix.Write(b)
+		titles.Set([]byte(u), []byte("page "+strconv.Itoa(i)))
+	}
+	return store
+}
+
+func urls(results []Result) []string {
+	out := []string{}
+	for _, r := range results {
+		out = append(out, r.URL)
+	}
+	return out
+}
+
+func TestSearchPaging(t *testing.T) {
+	s := New(testStore("a.test", 7), testStore("b.test", 6))
+	all, stats, err := s.Search("fish", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 13 || stats.Total != 13 {
+		t.Fatalf("got %d results, total %d, want 13", len(all), stats.Total)
+	}
+	for i := 1; i < len(all); i++ {
+		if better(all[i], all[i-1]) {
+			t.Errorf("result %d (%v) ranks above %d (%v)", i, all[i].Score, i-1, all[i-1].Score)
+		}
+	}
+	if all[0].Title == "" || len(all[0].Terms) != 1 || all[0].Terms[0] != "fish" {
+		t.Errorf("first result %+v, want a title and the term", all[0])
+	}
+
+	tests := []struct {
+		offset int
+		limit  int
+		from   int
+		to     int
+	}{
+		{0, 1, 0, 1},
+		{0, 5, 0, 5},
+		{5, 5, 5, 10},
+		{10, 5, 10, 13},
+		{12, 1, 12, 13},
+		{13, 5, 13, 13},
+		{20, 5, 13, 13},
+		{3, 0, 3, 13},
+		{0, 13, 0, 13},
+		{0, 100, 0, 13},
+	}
+	for _, tt := range tests {
+		got, stats, err := s.Search("fish", Options{Offset: tt.offset, Limit: tt.limit})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := urls(all[tt.from:tt.to]); !reflect.DeepEqual(urls(got), want) {
+			t.Errorf("offset %d limit %d: got %v, want %v", tt.offset, tt.limit, urls(got), want)
+		}
+		if stats.Total != 13 {
+			t.Errorf("offset %d limit %d: total %d, want 13", tt.offset, tt.limit, stats.Total)
+		}
+	}
+}
+
+func TestSearchErrors(t *testing.T) {
+	s := New(testStore("a.test", 1))
+	tests := []struct {
+		text string
+		bad  bool
+	}{
+		{"", true},
+		{"the", true},
+		{"(fish OR ) other", true},
+		{"fish", false},
+	}
+	for _, tt := range tests {
+		_, _, err := s.Search(tt.text, Options{})
+		if BadQuery(err) != tt.bad {
+			t.Errorf("Search(%q) err %v, want bad query %v", tt.text, err, tt.bad)
+		}
+	}
+}
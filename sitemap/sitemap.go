@@ -0,0 +1,183 @@
+package sitemap
+
+/*
+	sitemap.xml fetching and parsing.
+	Handles both <urlset> documents and <sitemapindex> documents pointing at
+	more sitemaps, either of which may be gzip compressed.
+*/
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+//sitemaps are capped at 50MB uncompressed by the spec
+const maxBodyBytes = 50 * 1024 * 1024
+
+//how deep to follow sitemap indexes
+var MaxDepth = 3
+
+//stop walking after this many urls
+var MaxURLs = 50000
+
+//URL entry from a urlset
+type URL struct {
+	Loc        string `xml:"loc"`
+	LastMod    string `xml:"lastmod"`
+	ChangeFreq string `xml:"changefreq"`
+	Priority   string `xml:"priority"`
+}
+
+type urlset struct {
+	URLs []URL `xml:"url"`
+}
+
+type sitemapindex struct {
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+}
+
+var errLimit = errors.New("sitemap url limit reached")
+
+//Fetch a single sitemap document, returning its urls or, for an index, the child sitemaps
+func Fetch(client *http.Client, agent string, loc string) ([]URL, []string, error) {
+	req, err := http.NewRequest("GET", loc, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("User-Agent", agent)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("%s: %s", loc, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBodyBytes))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	//.xml.gz files usually come back as plain binary rather than Content-Encoding
+	if len(body) > 2 && body[0] == 0x1f && body[1] == 0x8b {
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, nil, err
+		}
+		body, err = ioutil.ReadAll(io.LimitReader(gz, maxBodyBytes))
+		gz.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return Parse(body)
+}
+
+//Parse a sitemap document
+func Parse(body []byte) ([]URL, []string, error) {
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	dec.Strict = false
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "urlset":
+			set := urlset{}
+			if err := dec.DecodeElement(&set, &start); err != nil {
+				return nil, nil, err
+			}
+			for i := range set.URLs {
+				set.URLs[i].Loc = strings.TrimSpace(set.URLs[i].Loc)
+				set.URLs[i].LastMod = strings.TrimSpace(set.URLs[i].LastMod)
+				set.URLs[i].Priority = strings.TrimSpace(set.URLs[i].Priority)
+			}
+			return set.URLs, nil, nil
+
+		case "sitemapindex":
+			ind := sitemapindex{}
+			if err := dec.DecodeElement(&ind, &start); err != nil {
+				return nil, nil, err
+			}
+			children := []string{}
+			for _, sm := range ind.Sitemaps {
+				if loc := strings.TrimSpace(sm.Loc); loc != "" {
+					children = append(children, loc)
+				}
+			}
+			return nil, children, nil
+
+		default:
+			return nil, nil, fmt.Errorf("not a sitemap: <%s>", start.Name.Local)
+		}
+	}
+}
+
+//Walk a sitemap and any sitemaps it indexes, calling fn for every url found
+func Walk(client *http.Client, agent string, loc string, fn func(URL)) error {
+	count := 0
+	seen := map[string]bool{}
+	err := walk(client, agent, loc, 0, seen, &count, fn)
+	if err == errLimit {
+		return nil
+	}
+	return err
+}
+
+func walk(client *http.Client, agent string, loc string, depth int, seen map[string]bool, count *int, fn func(URL)) error {
+	if seen[loc] {
+		return nil
+	}
+	seen[loc] = true
+
+	fmt.Println("Sitemap: " + loc)
+	urls, children, err := Fetch(client, agent, loc)
+	if err != nil {
+		return err
+	}
+
+	for _, u := range urls {
+		if u.Loc == "" {
+			continue
+		}
+		fn(u)
+		*count++
+		if *count >= MaxURLs {
+			return errLimit
+		}
+	}
+
+	if depth >= MaxDepth {
+		return nil
+	}
+	for _, child := range children {
+		err := walk(client, agent, child, depth+1, seen, count, fn)
+		if err == errLimit {
+			return err
+		} else if err != nil {
+			//one bad child shouldnt lose the rest
+			fmt.Println("Err-Sitemap: ", err)
+		}
+	}
+	return nil
+}
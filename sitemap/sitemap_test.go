@@ -0,0 +1,150 @@
+package sitemap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func urlsetOf(locs ...string) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?><urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`)
+	for _, loc := range locs {
+		b.WriteString("<url><loc>" + loc + "</loc></url>")
+	}
+	b.WriteString("</urlset>")
+	return b.String()
+}
+
+func indexOf(locs ...string) string {
+	var b strings.Builder
+	b.WriteString(`<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`)
+	for _, loc := range locs {
+		b.WriteString("<sitemap><loc>" + loc + "</loc></sitemap>")
+	}
+	b.WriteString("</sitemapindex>")
+	return b.String()
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		urls     []URL
+		children []string
+		err      bool
+	}{
+		{"urlset", `<urlset><url><loc> http://a/1 </loc><lastmod>2020-01-01</lastmod><priority> 0.5 </priority></url><url><loc>http://a/2</loc></url></urlset>`,
+			[]URL{{Loc: "http://a/1", LastMod: "2020-01-01", Priority: "0.5"}, {Loc: "http://a/2"}}, nil, false},
+		{"index", indexOf("http://a/s1.xml", " ", "http://a/s2.xml.gz"), nil, []string{"http://a/s1.xml", "http://a/s2.xml.gz"}, false},
+		{"empty urlset", "<urlset></urlset>", nil, nil, false},
+		{"not a sitemap", "<html><body>hi</body></html>", nil, nil, true},
+		{"not xml", "just text", nil, nil, true},
+	}
+	for _, tt := range tests {
+		urls, children, err := Parse([]byte(tt.body))
+		if (err != nil) != tt.err {
+			t.Errorf("%s: err %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(urls, tt.urls) || !reflect.DeepEqual(children, tt.children) {
+			t.Errorf("%s: got %+v %v, want %+v %v", tt.name, urls, children, tt.urls, tt.children)
+		}
+	}
+}
+
+func gzipped(s string) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(s))
+	w.Close()
+	return buf.Bytes()
+}
+
+//a site serving the given paths, others 404
+func site(t *testing.T, pages map[string][]byte) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, ok := pages[req.URL.Path]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFetch(t *testing.T) {
+	pages := map[string][]byte{
+		"/plain.xml": []byte(urlsetOf("http://a/1")),
+		"/gz.xml.gz": gzipped(urlsetOf("http://a/2")),
+		"/index.xml": []byte(indexOf("http://a/plain.xml")),
+		"/broken.gz": {0x1f, 0x8b, 0x00},
+		"/large.xml": []byte(urlsetOf(strings.Repeat("x", 11*1024*1024))), //over the crawler's default max-body
+	}
+	srv := site(t, pages)
+	tests := []struct {
+		path     string
+		urls     int
+		children int
+		err      bool
+	}{
+		{"/plain.xml", 1, 0, false},
+		{"/gz.xml.gz", 1, 0, false},
+		{"/index.xml", 0, 1, false},
+		{"/broken.gz", 0, 0, true},
+		{"/missing.xml", 0, 0, true},
+		{"/large.xml", 1, 0, false},
+	}
+	for _, tt := range tests {
+		urls, children, err := Fetch(srv.Client(), "test", srv.URL+tt.path)
+		if (err != nil) != tt.err || len(urls) != tt.urls || len(children) != tt.children {
+			t.Errorf("%s: %d urls, %d children, err %v", tt.path, len(urls), len(children), err)
+		}
+	}
+}
+
+func TestWalkLimits(t *testing.T) {
+	maxURLs, maxDepth := MaxURLs, MaxDepth
+	defer func() { MaxURLs, MaxDepth = maxURLs, maxDepth }()
+
+	pages := map[string][]byte{}
+	srv := site(t, pages)
+	//index -> level1 -> level2 -> level3, each level with 2 urls and the next index, plus a loop back
+	for i := 0; i < 4; i++ {
+		next := srv.URL + "/level" + strconv.Itoa(i+1) + ".xml"
+		pages["/level"+strconv.Itoa(i)+".xml"] = []byte(indexOf(next, srv.URL+"/level0.xml", srv.URL+"/urls"+strconv.Itoa(i)+".xml"))
+		pages["/urls"+strconv.Itoa(i)+".xml"] = []byte(urlsetOf("http://a/"+strconv.Itoa(i)+"a", "http://a/"+strconv.Itoa(i)+"b"))
+	}
+	pages["/level4.xml"] = []byte(urlsetOf("http://a/deepest"))
+
+	tests := []struct {
+		maxURLs  int
+		maxDepth int
+		want     int
+	}{
+		{100, 0, 0}, //only the index itself, which has no urls
+		{100, 1, 2}, //urls0
+		{100, 2, 4},
+		{100, 10, 9}, //every urlset and the deepest page, the loop back only once
+		{3, 10, 3},
+		{1, 10, 1},
+	}
+	for _, tt := range tests {
+		MaxURLs, MaxDepth = tt.maxURLs, tt.maxDepth
+		got := 0
+		err := Walk(srv.Client(), "test", srv.URL+"/level0.xml", func(u URL) { got++ })
+		if err != nil {
+			t.Errorf("MaxURLs %d MaxDepth %d: %v", tt.maxURLs, tt.maxDepth, err)
+		}
+		if got != tt.want {
+			t.Errorf("MaxURLs %d MaxDepth %d: %d urls, want %d", tt.maxURLs, tt.maxDepth, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package snippet
+
+/*
+	Result snippets.
+	The crawler stores each page's visible text compressed, the searcher
+	picks the window of it with the most query terms and marks the words
+	that matched, for html (<mark>) or a terminal (bold).
+*/
+
+import (
+	"bytes"
+	"compress/flate"
+	"html"
+	"io/ioutil"
+	"strings"
+	"unicode/utf8"
+
+	"../tokenizer"
+)
+
+//MaxText stored per page, in bytes. snippets only come from the start of longer pages
+const MaxText = 64 * 1024
+
+//Words in a snippet
+const Words = 30
+
+//words of context kept before the first match
+const lead = 5
+
+//Fragment of snippet text, Match if it is a query term
+type Fragment struct {
+	Text  string
+	Match bool
+}
+
+//Snippet of a page
+type Snippet []Fragment
+
+//Compress page text for storing
+func Compress(text string) []byte {
+	if len(text) > MaxText {
+		n := MaxText
+		for n > 0 && !utf8.RuneStart(text[n]) {
+			n--
+		}
+		text = text[:n]
+	}
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestSpeed)
+	w.Write([]byte(text))
+	w.Close()
+	return buf.Bytes()
+}
+
+//Decompress stored page text
+func Decompress(data []byte) (string, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	text, err := ioutil.ReadAll(r)
+	return string(text), err
+}
+
+//Best window of the text for terms, which are index terms as in tokenizer.Terms
+func Best(text string, terms []string) Snippet {
+	tokens := tokenizer.Tokens(text)
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	want := map[string]bool{}
+	for _, t := range terms {
+		want[t] = true
+	}
+	match := make([]string, len(tokens))
+	for i, t := range tokens {
+		if term := tokenizer.Term(t.Word); want[term] {
+			match[i] = term
+		}
+	}
+
+	//slide a window over the words, more distinct terms beats more hits
+	best, bestScore := 0, -1
+	for start := 0; start < len(tokens); start++ {
+		end := start + Words
+		if end > len(tokens) {
+			end = len(tokens)
+		}
+		distinct := map[string]bool{}
+		hits := 0
+		for i := start; i < end; i++ {
+			if match[i] != "" {
+				distinct[match[i]] = true
+				hits++
+			}
+		}
+		score := len(distinct)*Words + hits
+		if score > bestScore {
+			best, bestScore = start, score
+		}
+		//windows past here only get shorter
+		if end == len(tokens) {
+			break
+		}
+	}
+
+	//the first window with the most matches ends on them, move it up to a few words before the first
+	for i := best; i < len(tokens) && i < best+Words; i++ {
+		if match[i] != "" {
+			if i-lead > best {
+				best = i - lead
+			}
+			break
+		}
+	}
+
+	end := best + Words
+	if end > len(tokens) {
+		end = len(tokens)
+	}
+
+	s := Snippet{}
+	if best > 0 {
+		s = append(s, Fragment{Text: "..."})
+	}
+	last := tokens[best].Start
+	for i := best; i < end; i++ {
+		if match[i] == "" {
+			continue
+		}
+		if tokens[i].Start > last {
+			s = append(s, Fragment{Text: text[last:tokens[i].Start]})
+		}
+		s = append(s, Fragment{Text: text[tokens[i].Start:tokens[i].End], Match: true})
+		last = tokens[i].End
+	}
+	if tokens[end-1].End > last {
+		s = append(s, Fragment{Text: text[last:tokens[end-1].End]})
+	}
+	if end < len(tokens) {
+		s = append(s, Fragment{Text: "..."})
+	}
+	return s
+}
+
+//String of the snippet without highlighting
+func (s Snippet) String() string {
+	var b strings.Builder
+	for _, f := range s {
+		b.WriteString(f.Text)
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+//HTML of the snippet, escaped, with matches in <mark>
+func (s Snippet) HTML() string {
+	var b strings.Builder
+	for _, f := range s {
+		text := html.EscapeString(collapse(f.Text))
+		if f.Match {
+			b.WriteString("<mark>" + text + "</mark>")
+		} else {
+			b.WriteString(text)
+		}
+	}
+	return b.String()
+}
+
+//ANSI of the snippet for terminals, matches in bold
+func (s Snippet) ANSI() string {
+	var b strings.Builder
+	for _, f := range s {
+		text := collapse(f.Text)
+		if f.Match {
+			b.WriteString("\x1b[1m" + text + "\x1b[0m")
+		} else {
+			b.WriteString(text)
+		}
+	}
+	return b.String()
+}
+
+//runs of whitespace to one space, page text keeps its newlines and indenting
+func collapse(text string) string {
+	fields := strings.Fields(text)
+	out := strings.Join(fields, " ")
+	if len(fields) > 0 {
+		if strings.TrimLeft(text, " \t\r\n") != text {
+			out = " " + out
+		}
+		if strings.TrimRight(text, " \t\r\n") != text {
+			out += " "
+		}
+	} else if text != "" {
+		out = " "
+	}
+	return out
+}
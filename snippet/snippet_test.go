@@ -0,0 +1,94 @@
+package snippet
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+//n filler words
+func filler(n int) string {
+	return strings.TrimSpace(strings.Repeat("lorem ", n))
+}
+
+func marked(s Snippet) []string {
+	out := []string{}
+	for _, f := range s {
+		if f.Match {
+			out = append(out, f.Text)
+		}
+	}
+	return out
+}
+
+func TestBest(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		terms  []string
+		marks  []string
+		prefix bool //starts with ...
+		suffix bool //ends with ...
+		has    string
+	}{
+		{"empty", "", []string{"fish"}, []string{}, false, false, ""},
+		{"short", "Gone Fishing, with cats.", []string{"fish", "cat"}, []string{"Fishing", "cats"}, false, false, "Gone Fishing, with cats"},
+		{"no match", filler(50), []string{"fish"}, []string{}, false, true, "lorem"},
+		{"match late", filler(100) + " big fish " + filler(100), []string{"fish"}, []string{"fish"}, true, true, "lorem big fish lorem"},
+		{"distinct beats hits", "fish fish fish fish " + filler(60) + " cat fish " + filler(60), []string{"fish", "cat"}, []string{"cat", "fish"}, true, true, "cat fish"},
+		{"end of text", filler(100) + " last fish", []string{"fish"}, []string{"fish"}, true, false, "last fish"},
+	}
+	for _, tt := range tests {
+		s := Best(tt.text, tt.terms)
+		if got := marked(s); !reflect.DeepEqual(got, tt.marks) {
+			t.Errorf("%s: marked %q, want %q", tt.name, got, tt.marks)
+		}
+		str := s.String()
+		if strings.HasPrefix(str, "...") != tt.prefix || strings.HasSuffix(str, "...") != tt.suffix {
+			t.Errorf("%s: %q, want prefix %v suffix %v", tt.name, str, tt.prefix, tt.suffix)
+		}
+		if !strings.Contains(str, tt.has) {
+			t.Errorf("%s: %q doesnt contain %q", tt.name, str, tt.has)
+		}
+		if n := len(strings.Fields(strings.Trim(str, "."))); n > Words {
+			t.Errorf("%s: %d words, want at most %d", tt.name, n, Words)
+		}
+	}
+}
+
+func TestFormats(t *testing.T) {
+	s := Best("a <b>\n\n   fish & chips", []string{"fish"})
+	if got, want := s.HTML(), "a &lt;b&gt; <mark>fish</mark> &amp; chips"; got != want {
+		t.Errorf("HTML = %q, want %q", got, want)
+	}
+	if got, want := s.ANSI(), "a <b> \x1b[1mfish\x1b[0m & chips"; got != want {
+		t.Errorf("ANSI = %q, want %q", got, want)
+	}
+}
+
+func TestCompress(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"empty", "", ""},
+		{"short", "some page text", "some page text"},
+		{"cut to MaxText", strings.Repeat("a", MaxText+10), strings.Repeat("a", MaxText)},
+		{"cut on a rune", strings.Repeat("a", MaxText-1) + "é", strings.Repeat("a", MaxText-1)},
+	}
+	for _, tt := range tests {
+		got, err := Decompress(Compress(tt.text))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want || !utf8.ValidString(got) {
+			t.Errorf("%s: got %d bytes, want %d", tt.name, len(got), len(tt.want))
+		}
+	}
+	if _, err := Decompress([]byte("not flate")); err == nil {
+		t.Error("Decompress of garbage succeeded")
+	}
+}
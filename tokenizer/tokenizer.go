@@ -0,0 +1,235 @@
+package tokenizer
+
+/*
+	Splitting text into index terms.
+	The crawler and the searchers both go through Terms so a query word
+	always ends up as the same term the page's word was indexed under.
+	Words are runs of letters and digits in any script, lowercased with
+	their diacritics removed. Chinese and Japanese are written without
+	spaces, so each ideograph or kana is a word of its own and phrases
+	match runs of them. English words are then stemmed.
+	Stopwords are loaded from one file per language (stopwords/en.txt...),
+	one word per line with # comments. They arent indexed but still take
+	up a position, so phrases containing them match.
+*/
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+//DefaultStopwordDir the crawler and searchers load from
+const DefaultStopwordDir = "./stopwords"
+
+//stopwords in use until some are loaded
+var stopwords = map[string]bool{"and": true, "the": true, "not": true}
+
+//letters with diacritics (and a few ligatures) and what they fold to
+var folds = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'đ': "d", 'ð': "d", 'þ': "th",
+	'ł': "l", 'ı': "i", 'ħ': "h", 'ŧ': "t", 'ŋ': "n", 'ĸ': "k", 'ſ': "s", 'ς': "σ",
+}
+
+func init() {
+	groups := map[string]string{
+		"a": "àáâãäåāăąǎǻạảấầẩẫậắằẳẵặ",
+		"c": "çćĉċč",
+		"d": "ď",
+		"e": "èéêëēĕėęěẹẻẽếềểễệ",
+		"g": "ĝğġģǧ",
+		"h": "ĥ",
+		"i": "ìíîïĩīĭįǐỉị",
+		"j": "ĵ",
+		"k": "ķǩ",
+		"l": "ĺļľŀ",
+		"n": "ñńņňŉǹ",
+		"o": "òóôõöōŏőǒơọỏốồổỗộớờởỡợ",
+		"r": "ŕŗř",
+		"s": "śŝşšș",
+		"t": "ţťț",
+		"u": "ùúûüũūŭůűųǔǖǘǚǜưụủứừửữự",
+		"w": "ŵẁẃẅ",
+		"y": "ýÿŷỳỵỷỹ",
+		"z": "źżž",
+	}
+	for base, letters := range groups {
+		for _, r := range letters {
+			folds[r] = base
+		}
+	}
+}
+
+//fold a rune to lowercase without diacritics
+func fold(r rune) string {
+	r = unicode.ToLower(r)
+	if f, ok := folds[r]; ok {
+		return f
+	}
+	return string(r)
+}
+
+//scripts written without spaces between words
+func ideographic(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r)
+}
+
+//Token is a folded word and where it was in the text, as byte offsets
+type Token struct {
+	Word  string
+	Start int
+	End   int
+}
+
+//Tokens of a text, in order
+func Tokens(text string) []Token {
+	tokens := []Token{}
+	var cur strings.Builder
+	start, end := 0, 0
+	flush := func() {
+		if cur.Len() > 0 {
+			tokens = append(tokens, Token{Word: cur.String(), Start: start, End: end})
+			cur.Reset()
+		}
+	}
+
+	for i, r := range text {
+		_, size := utf8.DecodeRuneInString(text[i:])
+		switch {
+		case ideographic(r):
+			flush()
+			tokens = append(tokens, Token{Word: string(r), Start: i, End: i + size})
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if cur.Len() == 0 {
+				start = i
+			}
+			cur.WriteString(fold(r))
+			end = i + size
+		case unicode.Is(unicode.Mn, r):
+			//combining accents, dropped like the precomposed ones
+			if cur.Len() > 0 {
+				end = i + size
+			}
+		case (r == '\'' || r == '’') && cur.Len() > 0 && letterAt(text, i+size):
+			//don't -> dont
+		default:
+			flush()
+		}
+	}
+	flush()
+	return tokens
+}
+
+func letterAt(text string, i int) bool {
+	if i >= len(text) {
+		return false
+	}
+	r, _ := utf8.DecodeRuneInString(text[i:])
+	return unicode.IsLetter(r)
+}
+
+//Words of a text, folded, in order
+func Words(text string) []string {
+	tokens := Tokens(text)
+	words := make([]string, len(tokens))
+	for i, t := range tokens {
+		words[i] = t.Word
+	}
+	return words
+}
+
+//Term a folded word is indexed under, "" if it isnt
+func Term(word string) string {
+	if !Keyword(word) {
+		return ""
+	}
+	return Stem(word)
+}
+
+//LoadStopwords replaces the stopwords with those of the given languages,
+//read from <dir>/<lang>.txt. No languages loads every file in dir.
+func LoadStopwords(dir string, langs []string) (int, error) {
+	if len(langs) == 0 {
+		files, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+		if err != nil {
+			return 0, err
+		}
+		for _, f := range files {
+			langs = append(langs, strings.TrimSuffix(filepath.Base(f), ".txt"))
+		}
+		//nothing there, keep what we have
+		if len(langs) == 0 {
+			return len(stopwords), nil
+		}
+	}
+
+	loaded := map[string]bool{}
+	for _, lang := range langs {
+		f, err := os.Open(filepath.Join(dir, strings.TrimSpace(lang)+".txt"))
+		if err != nil {
+			return 0, err
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if i := strings.Index(line, "#"); i >= 0 {
+				line = line[:i]
+			}
+			//folded like any other text so they match what the page says
+			for _, w := range Words(line) {
+				loaded[w] = true
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	stopwords = loaded
+	return len(loaded), nil
+}
+
+//DisableStopwords so every word is indexed and searched for
+func DisableStopwords() {
+	stopwords = map[string]bool{}
+}
+
+//Configure stopwords from command line flags, langs comma separated
+func Configure(dir string, langs string, disabled bool) (int, error) {
+	if disabled {
+		DisableStopwords()
+		return 0, nil
+	}
+	list := []string{}
+	for _, l := range strings.Split(langs, ",") {
+		if strings.TrimSpace(l) != "" {
+			list = append(list, strings.TrimSpace(l))
+		}
+	}
+	return LoadStopwords(dir, list)
+}
+
+//Stopword reports whether a folded word is too common to index
+func Stopword(word string) bool {
+	return stopwords[word]
+}
+
+//Keyword reports whether a folded word is worth indexing
+func Keyword(word string) bool {
+	return word != "" && !Stopword(word)
+}
+
+//Terms of a text as they are indexed. Words that arent indexed are left
+//as "" so positions still line up.
+func Terms(text string) []string {
+	words := Words(text)
+	for i, w := range words {
+		words[i] = Term(w)
+	}
+	return words
+}
@@ -0,0 +1,36 @@
+package tokenizer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokens(t *testing.T) {
+	tests := []struct {
+		text string
+		want []Token
+	}{
+		{"", []Token{}},
+		{"Hello, World", []Token{{"hello", 0, 5}, {"world", 7, 12}}},
+		{"Café crème", []Token{{"cafe", 0, 5}, {"creme", 6, 12}}},
+		{"Cafe\u0301", []Token{{"cafe", 0, 6}}}, //combining accent
+		{"don't 'quote'", []Token{{"dont", 0, 5}, {"quote", 7, 12}}},
+		{"Straße", []Token{{"strasse", 0, 7}}},
+		{"東京 tower", []Token{{"東", 0, 3}, {"京", 3, 6}, {"tower", 7, 12}}},
+		{"x86-64", []Token{{"x86", 0, 3}, {"64", 4, 6}}},
+	}
+	for _, tt := range tests {
+		if got := Tokens(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Tokens(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestTerms(t *testing.T) {
+	//stopwords keep their place so phrases still line up
+	got := Terms("The cats and the Dogs, running")
+	want := []string{"", "cat", "", "", "dog", "run"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Terms = %q, want %q", got, want)
+	}
+}
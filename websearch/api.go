@@ -0,0 +1,120 @@
+package websearch
+
+/*
+	JSON search api for scripts and editors.
+	GET /api/search?q=...&offset=0&limit=10
+*/
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"../search"
+)
+
+//DefaultLimit of api results when none is asked for
+const DefaultLimit = 10
+
+//MaxLimit of api results per request
+const MaxLimit = 100
+
+type apiResult struct {
+	URL         string   `json:"url"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Score       float64  `json:"score"`
+	Terms       []string `json:"terms"`
+	Snippet     string   `json:"snippet"`
+	SnippetHTML string   `json:"snippet_html"`
+}
+
+type apiResponse struct {
+	Query   string      `json:"query"`
+	Total   int         `json:"total"`
+	Offset  int         `json:"offset"`
+	Limit   int         `json:"limit"`
+	TookMs  float64     `json:"took_ms"`
+	Results []apiResult `json:"results"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+//whole number query parameter, def if missing
+func intParam(req *http.Request, name string, def int) (int, bool) {
+	val := req.URL.Query().Get(name)
+	if val == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+func (s *Server) apiHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeJSON(w, http.StatusMethodNotAllowed, apiError{"method not allowed"})
+		return
+	}
+
+	q := req.URL.Query().Get("q")
+	if q == "" {
+		writeJSON(w, http.StatusBadRequest, apiError{"missing q"})
+		return
+	}
+	offset, ok := intParam(req, "offset", 0)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, apiError{"offset must be a whole number"})
+		return
+	}
+	limit, ok := intParam(req, "limit", DefaultLimit)
+	if !ok || limit == 0 || limit > MaxLimit {
+		writeJSON(w, http.StatusBadRequest, apiError{"limit must be between 1 and " + strconv.Itoa(MaxLimit)})
+		return
+	}
+
+	results, stats, err := s.searcher.Search(q, search.Options{Offset: offset, Limit: limit})
+	if search.BadQuery(err) {
+		writeJSON(w, http.StatusBadRequest, apiError{err.Error()})
+		return
+	} else if err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{err.Error()})
+		return
+	}
+
+	resp := apiResponse{
+		Query:   q,
+		Total:   stats.Total,
+		Offset:  offset,
+		Limit:   limit,
+		TookMs:  stats.Elapsed.Seconds() * 1000.0,
+		Results: []apiResult{},
+	}
+	for _, r := range results {
+		terms := r.Terms
+		if terms == nil {
+			terms = []string{}
+		}
+		resp.Results = append(resp.Results, apiResult{
+			URL:         r.URL,
+			Title:       r.Title,
+			Description: r.Description,
+			Score:       r.Score,
+			Terms:       terms,
+			Snippet:     r.Snippet.String(),
+			SnippetHTML: r.Snippet.HTML(),
+		})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
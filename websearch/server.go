@@ -0,0 +1,112 @@
+package websearch
+
+/*
+	The search server.
+	Serves the web ui, /api/search and /static/ on its own mux so it can be
+	embedded next to other handlers, over https when a cert and key are set.
+*/
+
+import (
+	"context"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"../search"
+)
+
+//Config of a search server
+type Config struct {
+	Addr           string
+	CertFile       string //https when both CertFile and KeyFile are set
+	KeyFile        string
+	Dir            string //where the .gkv files are
+	ReadTimeout    time.Duration
+	WriteTimeout   time.Duration
+	ReloadInterval time.Duration //how often Dir is checked for new or updated .gkv files
+}
+
+//DefaultConfig serves plain http on :8888 from the current directory
+func DefaultConfig() Config {
+	return Config{
+		Addr:           ":8888",
+		Dir:            "./",
+		ReadTimeout:    15 * time.Second,
+		WriteTimeout:   30 * time.Second,
+		ReloadInterval: 10 * time.Second,
+	}
+}
+
+//Server of searches over a directory of stores
+type Server struct {
+	Config Config
+
+	searcher  *search.Reloader
+	templates *template.Template
+	http      *http.Server
+}
+
+//NewServer with its templates loaded and stores opened, not yet listening
+func NewServer(c Config) (*Server, error) {
+	t, err := loadTemplates()
+	if err != nil {
+		return nil, err
+	}
+	searcher, err := search.NewReloader(c.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{Config: c, searcher: searcher, templates: t}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handler)
+	mux.HandleFunc("/api/search", s.apiHandler)
+	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(newStaticFS())))
+
+	s.http = &http.Server{
+		Addr:         c.Addr,
+		Handler:      mux,
+		ReadTimeout:  c.ReadTimeout,
+		WriteTimeout: c.WriteTimeout,
+	}
+	return s, nil
+}
+
+//Handler of the server's routes, for mounting elsewhere
+func (s *Server) Handler() http.Handler {
+	return s.http.Handler
+}
+
+//TLS if a cert and key are configured
+func (s *Server) TLS() bool {
+	return s.Config.CertFile != "" && s.Config.KeyFile != ""
+}
+
+//ListenAndServe until Shutdown, which returns nil rather than http.ErrServerClosed
+func (s *Server) ListenAndServe() error {
+	if s.Config.ReloadInterval > 0 {
+		go s.searcher.Watch(s.Config.ReloadInterval, func(err error) {
+			log.Println("Reload:", err)
+		})
+	}
+
+	var err error
+	if s.TLS() {
+		err = s.http.ListenAndServeTLS(s.Config.CertFile, s.Config.KeyFile)
+	} else {
+		err = s.http.ListenAndServe()
+	}
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+//Shutdown gracefully, waiting for requests in flight until ctx is done, then close the stores
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.http.Shutdown(ctx)
+	s.searcher.Close()
+	return err
+}
@@ -0,0 +1,61 @@
+package websearch
+
+/*
+	Templates and static files of the web ui.
+	Both are embedded in the binary. Any *.html in TemplateDir replaces the
+	embedded template of the same name, and files in StaticDir are served
+	ahead of the embedded ones under /static/, so the page can be themed
+	without rebuilding.
+*/
+
+import (
+	"embed"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+//go:embed templates/*.html static/*
+var assets embed.FS
+
+//TemplateDir with templates overriding the embedded ones
+var TemplateDir = "./templates"
+
+//StaticDir with files overriding the embedded ones
+var StaticDir = "./static"
+
+//load the embedded templates, then any overrides
+func loadTemplates() (*template.Template, error) {
+	t, err := template.ParseFS(assets, "templates/*.html")
+	if err != nil {
+		return nil, err
+	}
+
+	overrides, _ := filepath.Glob(filepath.Join(TemplateDir, "*.html"))
+	if len(overrides) > 0 {
+		return t.ParseFiles(overrides...)
+	}
+	return t, nil
+}
+
+//static files from StaticDir if they are there, embedded otherwise
+type staticFS struct {
+	dir      http.FileSystem
+	embedded http.FileSystem
+}
+
+func newStaticFS() http.FileSystem {
+	sub, _ := fs.Sub(assets, "static")
+	return staticFS{dir: http.Dir(StaticDir), embedded: http.FS(sub)}
+}
+
+func (s staticFS) Open(name string) (http.File, error) {
+	if f, err := s.dir.Open(name); err == nil {
+		return f, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return s.embedded.Open(name)
+}
@@ -0,0 +1,215 @@
+package websearch
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/steveyen/gkvlite"
+
+	"../posting"
+	"../search"
+)
+
+const evilTitle = "<script>alert(1)</script> Fish"
+
+//a server over a store with one page about fish, with no template or static overrides
+func testServer(t *testing.T) *Server {
+	dir := t.TempDir()
+	f, err := os.Create(filepath.Join(dir, "db.gkv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, _ := gkvlite.NewStore(f)
+	ix := posting.Open(store)
+	doc, _ := ix.DocID("http://x.test/", true)
+	b := posting.NewBatch()
+	b.Replace(doc)
+	b.Add(doc, "fish", posting.Body)
+	ix.Write(b)
+	store.SetCollection("title", nil).Set([]byte("http://x.test/"), []byte(evilTitle))
+	store.Flush()
+	f.Close()
+
+	templateDir, staticDir := TemplateDir, StaticDir
+	t.Cleanup(func() { TemplateDir, StaticDir = templateDir, staticDir })
+	TemplateDir = filepath.Join(dir, "templates")
+	StaticDir = filepath.Join(dir, "static")
+	config := DefaultConfig()
+	config.Dir = dir
+	config.ReloadInterval = 0
+	s, err := NewServer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
+	return s
+}
+
+func get(s *Server, method string, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest(method, target, nil))
+	return w
+}
+
+func TestAPI(t *testing.T) {
+	s := testServer(t)
+	tests := []struct {
+		name   string
+		method string
+		query  string
+		code   int
+	}{
+		{"ok", "GET", "q=fish", http.StatusOK},
+		{"head", "HEAD", "q=fish", http.StatusOK},
+		{"post", "POST", "q=fish", http.StatusMethodNotAllowed},
+		{"missing q", "GET", "", http.StatusBadRequest},
+		{"negative offset", "GET", "q=fish&offset=-1", http.StatusBadRequest},
+		{"offset not a number", "GET", "q=fish&offset=x", http.StatusBadRequest},
+		{"zero limit", "GET", "q=fish&limit=0", http.StatusBadRequest},
+		{"limit too big", "GET", "q=fish&limit=101", http.StatusBadRequest},
+		{"limit not a number", "GET", "q=fish&limit=ten", http.StatusBadRequest},
+		{"syntax error", "GET", "q=" + url.QueryEscape("(a OR ) b"), http.StatusBadRequest},
+		{"nothing to search for", "GET", "q=the", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := get(s, tt.method, "/api/search?"+tt.query)
+		if w.Code != tt.code {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.code, w.Body)
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+			t.Errorf("%s: Content-Type %q", tt.name, ct)
+		}
+		if tt.code == http.StatusMethodNotAllowed && w.Header().Get("Allow") != "GET, HEAD" {
+			t.Errorf("%s: Allow %q", tt.name, w.Header().Get("Allow"))
+		}
+		if tt.code != http.StatusOK && tt.method != "HEAD" {
+			var e apiError
+			if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil || e.Error == "" {
+				t.Errorf("%s: error body %q", tt.name, w.Body)
+			}
+		}
+	}
+
+	var resp apiResponse
+	w := get(s, "GET", "/api/search?q=fish&limit=5")
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Total != 1 || resp.Limit != 5 || len(resp.Results) != 1 || resp.Results[0].Title != evilTitle {
+		t.Errorf("response %+v", resp)
+	}
+	if strings.Contains(w.Body.String(), "<script>") {
+		t.Errorf("unescaped title in json: %s", w.Body)
+	}
+}
+
+func TestPageEscapes(t *testing.T) {
+	s := testServer(t)
+
+	//the query is echoed in the title and the search box
+	w := get(s, "GET", "/?search="+url.QueryEscape(`"><script>x</script>`))
+	if body := w.Body.String(); w.Code != http.StatusOK || strings.Contains(body, "<script>") || !strings.Contains(body, `value="&#34;&gt;&lt;script&gt;`) {
+		t.Errorf("query not escaped, status %d:\n%s", w.Code, body)
+	}
+
+	//and crawled titles are whatever the page said
+	w = get(s, "GET", "/?search="+url.QueryEscape("fish <script>"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	body := w.Body.String()
+	if strings.Contains(body, "<script>") {
+		t.Errorf("unescaped script in page:\n%s", body)
+	}
+	if !strings.Contains(body, "&lt;script&gt;alert(1)&lt;/script&gt; Fish") {
+		t.Errorf("title not shown escaped:\n%s", body)
+	}
+	if !strings.Contains(body, "http://x.test/") {
+		t.Errorf("result missing:\n%s", body)
+	}
+
+	if w := get(s, "GET", "/nothing-here"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown path status %d", w.Code)
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	s := testServer(t)
+	embeddedCSS := get(s, "GET", "/static/style.css")
+	if embeddedCSS.Code != http.StatusOK || embeddedCSS.Body.Len() == 0 {
+		t.Fatalf("embedded style.css: %d", embeddedCSS.Code)
+	}
+
+	//templates are loaded when the server is made, static files on each request
+	os.MkdirAll(TemplateDir, 0755)
+	os.MkdirAll(StaticDir, 0755)
+	os.WriteFile(filepath.Join(TemplateDir, "search.html"), []byte(`custom {{.Query}}`), 0644)
+	os.WriteFile(filepath.Join(StaticDir, "style.css"), []byte("body{}"), 0644)
+	os.WriteFile(filepath.Join(StaticDir, "extra.js"), []byte("//extra"), 0644)
+	t2, err := loadTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.templates = t2
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/?search=" + url.QueryEscape("<b>"), "custom &lt;b&gt;"},
+		{"/static/style.css", "body{}"},
+		{"/static/extra.js", "//extra"},
+	}
+	for _, tt := range tests {
+		w := get(s, "GET", tt.path)
+		if w.Code != http.StatusOK || w.Body.String() != tt.want {
+			t.Errorf("%s: %d %q, want %q", tt.path, w.Code, w.Body, tt.want)
+		}
+	}
+
+	//a broken override fails loading rather than serving half a page
+	os.WriteFile(filepath.Join(TemplateDir, "search.html"), []byte(`{{.Query`), 0644)
+	if _, err := loadTemplates(); err == nil {
+		t.Error("broken template override loaded")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	s := testServer(t)
+	s.Config.ReloadInterval = time.Hour
+	s.http.Addr = "127.0.0.1:0"
+
+	done := make(chan error, 1)
+	go func() { done <- s.ListenAndServe() }()
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("ListenAndServe after Shutdown: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ListenAndServe didnt return")
+	}
+
+	//the stores are closed too
+	if _, _, err := s.searcher.Search("fish", search.Options{}); err != search.ErrClosed {
+		t.Errorf("search after Shutdown: %v, want ErrClosed", err)
+	}
+	if w := get(s, "GET", "/api/search?q=fish"); w.Code != http.StatusInternalServerError {
+		t.Errorf("api after Shutdown: %d", w.Code)
+	}
+}